@@ -0,0 +1,208 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"math"
+	"math/big"
+)
+
+// Toi ints are Go ints, unless they don't fit, in which case they are transparently promoted to a *big.Int. Results
+// are always normalized, so a *big.Int value never fits in an int (and is thus never equal to any int).
+
+func normalizeBig(b *big.Int) any {
+	if b.IsInt64() && b.Int64() >= math.MinInt && b.Int64() <= math.MaxInt {
+		return int(b.Int64())
+	}
+	return b
+}
+
+func toBig(v any) *big.Int {
+	if i, ok := v.(int); ok {
+		return big.NewInt(int64(i))
+	}
+	return v.(*big.Int)
+}
+
+func isNumber(v any) bool {
+	switch v.(type) {
+	case int, *big.Int:
+		return true
+	}
+	return false
+}
+
+func castToNumber(v any, side, operator string) (any, error) {
+	if !isNumber(v) {
+		return nil, fmt.Errorf("%s-hand operand of '%s' should be an int but was '%v'", side, operator, formatValue(v))
+	}
+	return v, nil
+}
+
+// maxResultBits limits the size of the results of shl and pow(), which would otherwise run out of memory for large
+// shift counts and exponents
+const maxResultBits = 1 << 20
+
+// arithmetic applies an arithmetic (+ - * / %) or bitwise (band bor xor shl shr) operator to two ints
+func arithmetic(left, right any, operator string) (any, error) {
+	left, err := castToNumber(left, "left", operator)
+	if err != nil {
+		return nil, err
+	}
+	right, err = castToNumber(right, "right", operator)
+	if err != nil {
+		return nil, err
+	}
+
+	if (operator == "/" || operator == "%") && right == 0 {
+		return nil, fmt.Errorf("division by zero")
+	}
+	if operator == "shl" || operator == "shr" {
+		if count, ok := right.(int); !ok || count < 0 {
+			return nil, fmt.Errorf("shift count should be a non-negative int but was '%v'", formatValue(right))
+		} else if operator == "shl" && count > maxResultBits {
+			return nil, fmt.Errorf("shift count %d is too large; it can be at most %d", count, maxResultBits)
+		}
+	}
+
+	l, leftIsInt := left.(int)
+	r, rightIsInt := right.(int)
+	if leftIsInt && rightIsInt {
+		if result, ok := intArithmetic(l, r, operator); ok {
+			return result, nil
+		}
+	}
+	return bigArithmetic(toBig(left), toBig(right), operator)
+}
+
+// intArithmetic returns false if the result overflows
+func intArithmetic(l, r int, operator string) (int, bool) {
+	switch operator {
+	case "+":
+		sum := l + r
+		return sum, (l >= 0) != (r >= 0) || (sum >= 0) == (l >= 0)
+	case "-":
+		difference := l - r
+		return difference, (l >= 0) == (r >= 0) || (difference >= 0) == (l >= 0)
+	case "*":
+		if l == 0 || r == 0 {
+			return 0, true
+		}
+		product := l * r
+		return product, product/r == l && !(l == -1 && r == math.MinInt) && !(r == -1 && l == math.MinInt)
+	case "/":
+		return l / r, !(l == math.MinInt && r == -1)
+	case "%":
+		return l % r, true
+	case "band":
+		return l & r, true
+	case "bor":
+		return l | r, true
+	case "xor":
+		return l ^ r, true
+	case "shl":
+		if r >= 64 {
+			return 0, l == 0
+		}
+		shifted := l << r
+		return shifted, shifted>>r == l
+	case "shr":
+		return l >> min(r, 63), true
+	}
+	panic(fmt.Sprintf("unknown arithmetic operator '%s'", operator))
+}
+
+func bigArithmetic(l, r *big.Int, operator string) (any, error) {
+	result := new(big.Int)
+	switch operator {
+	case "+":
+		result.Add(l, r)
+	case "-":
+		result.Sub(l, r)
+	case "*":
+		result.Mul(l, r)
+	case "/":
+		// Quo and Rem truncate like Go's / and %, unlike Div and Mod
+		result.Quo(l, r)
+	case "%":
+		result.Rem(l, r)
+	case "band":
+		result.And(l, r)
+	case "bor":
+		result.Or(l, r)
+	case "xor":
+		result.Xor(l, r)
+	case "shl":
+		result.Lsh(l, uint(r.Int64()))
+	case "shr":
+		// Like shr on ints, this rounds towards negative infinity
+		result.Rsh(l, uint(r.Int64()))
+	default:
+		return nil, fmt.Errorf("unknown arithmetic operator '%s'", operator)
+	}
+	return normalizeBig(result), nil
+}
+
+// unaryArithmetic applies a unary operator (- or bnot) to an int
+func unaryArithmetic(operand any, operator string) (any, error) {
+	if !isNumber(operand) {
+		return nil, fmt.Errorf("operand of '%s' should be an int but was '%v'", operator, formatValue(operand))
+	}
+
+	switch operator {
+	case "-":
+		if i, ok := operand.(int); ok && i != math.MinInt {
+			return -i, nil
+		}
+		return normalizeBig(new(big.Int).Neg(toBig(operand))), nil
+	case "bnot":
+		if i, ok := operand.(int); ok {
+			return ^i, nil
+		}
+		return normalizeBig(new(big.Int).Not(toBig(operand))), nil
+	}
+	return nil, fmt.Errorf("unknown unary operator '%s'", operator)
+}
+
+// compareNumbers compares two ints, and returns 1 (true) or 0 (false) depending on what test returns for the
+// comparison result
+func compareNumbers(left, right any, operator string, test func(c int) bool) (any, error) {
+	left, err := castToNumber(left, "left", operator)
+	if err != nil {
+		return nil, err
+	}
+	right, err = castToNumber(right, "right", operator)
+	if err != nil {
+		return nil, err
+	}
+	return boolToInt(test(cmpNumbers(left, right))), nil
+}
+
+func cmpNumbers(left, right any) int {
+	l, leftIsInt := left.(int)
+	r, rightIsInt := right.(int)
+	if leftIsInt && rightIsInt {
+		return cmp.Compare(l, r)
+	}
+	return toBig(left).Cmp(toBig(right))
+}
+
+// rangeContinues returns whether a `for i = from..to step s` loop with the current value of i runs another iteration;
+// the end is exclusive, and a negative step counts down
+func rangeContinues(value, end, step any) (bool, error) {
+	if !isNumber(value) {
+		return false, fmt.Errorf("'for' loop variable should be an int but was '%v'", formatValue(value))
+	} else if !isNumber(end) {
+		return false, fmt.Errorf("'for' range end should be an int but was '%v'", formatValue(end))
+	} else if !isNumber(step) {
+		return false, fmt.Errorf("'for' step should be an int but was '%v'", formatValue(step))
+	}
+
+	switch cmpNumbers(step, 0) {
+	case 1:
+		return cmpNumbers(value, end) < 0, nil
+	case -1:
+		return cmpNumbers(value, end) > 0, nil
+	}
+	return false, fmt.Errorf("'for' step cannot be 0")
+}
@@ -28,12 +28,24 @@ type TypeStatement struct {
 	Identifier Token
 	Fields     []Token
 	FieldMap   map[string]int
+	Defaults   []Expression // default value for each field, or nil if the field has none
 }
 
 func (s *TypeStatement) lineCol() LineCol {
 	return s.Token.LineCol()
 }
 
+type ImportStatement struct {
+	Token      Token
+	Path       string
+	Namespace  string
+	Statements []Statement
+}
+
+func (s *ImportStatement) lineCol() LineCol {
+	return s.Token.LineCol()
+}
+
 type IfStatement struct {
 	Token     Token
 	Condition Expression
@@ -45,6 +57,77 @@ func (s *IfStatement) lineCol() LineCol {
 	return s.Token.LineCol()
 }
 
+// MatchStatement runs the body of the first arm with a pattern that matches the value of the expression, or the
+// otherwise block if none do
+type MatchStatement struct {
+	Token      Token
+	Subject    Token // hidden variable holding the value being matched
+	Expression Expression
+	Arms       []MatchArm
+	Otherwise  Statement // nil when there is no otherwise block
+}
+
+func (s *MatchStatement) lineCol() LineCol {
+	return s.Token.LineCol()
+}
+
+type MatchArm struct {
+	Patterns []Pattern // the arm matches when any of these patterns matches
+	Body     Statement
+}
+
+// Pattern is a pattern in an arm of a match statement; matching sets the variables that the pattern binds
+type Pattern interface {
+	matches(v any, env Env) (bool, error)
+	// compileTest writes ops that test the value loaded by load, and adds the indexes of the jumps to take when the
+	// test fails to failJumps
+	compileTest(compiler *Compiler, load func() error, failJumps *[]int) error
+}
+
+// LiteralPattern matches values equal to a number, string or nil literal
+type LiteralPattern struct {
+	Literal *LiteralExpression
+}
+
+// WildcardPattern (_) matches any value
+type WildcardPattern struct {
+	Token Token
+}
+
+// BindingPattern matches any value and assigns it to a variable; only used within array patterns
+type BindingPattern struct {
+	Identifier Token
+}
+
+// TypePattern matches values of a type (e.g. Point or int), and assigns the fields in Fields to variables of the same
+// name
+type TypePattern struct {
+	Token    Token
+	TypeName string
+	Fields   []Token
+}
+
+// ArrayPattern matches arrays and tuples with one element for each element pattern; with a rest pattern (..rest), it
+// matches when there are at least as many elements, and the remaining elements are assigned to the rest variable
+type ArrayPattern struct {
+	Token    Token
+	Elements []Pattern
+	HasRest  bool
+	Rest     Token // TokenUnderscore when the remaining elements are not needed
+}
+
+// AttemptStatement runs the failure block when the body fails with an error, which is assigned to ErrorVariable
+type AttemptStatement struct {
+	Token         Token
+	Body          Statement
+	ErrorVariable *Token // nil when the error is not needed
+	Failure       Statement
+}
+
+func (s *AttemptStatement) lineCol() LineCol {
+	return s.Token.LineCol()
+}
+
 type WhileStatement struct {
 	Token     Token
 	Condition Expression
@@ -56,6 +139,42 @@ func (s *WhileStatement) lineCol() LineCol {
 	return s.Token.LineCol()
 }
 
+// RangeConditionExpression is the condition of a `for i = from..to step s` loop: true while the loop variable has
+// not yet reached the end, counting up or down depending on the sign of the step
+type RangeConditionExpression struct {
+	Token    Token
+	Variable Token
+	End      Token
+	Step     Token
+}
+
+func (e *RangeConditionExpression) lineCol() LineCol {
+	return e.Token.LineCol()
+}
+
+// IteratorExpression creates the iterator over the container of a `for value = [container]key` loop
+type IteratorExpression struct {
+	Token     Token
+	Container Expression
+}
+
+func (e *IteratorExpression) lineCol() LineCol {
+	return e.Token.LineCol()
+}
+
+// IteratorNextExpression advances the iterator of a `for value = [container]key` loop and sets the key and value
+// variables; it is false once there are no more values
+type IteratorNextExpression struct {
+	Token    Token
+	Iterator Token
+	Key      Token
+	Value    Token
+}
+
+func (e *IteratorNextExpression) lineCol() LineCol {
+	return e.Token.LineCol()
+}
+
 type ExitFunctionStatement struct {
 	Token Token
 }
@@ -81,10 +200,10 @@ func (s *NextIterationStatement) lineCol() LineCol {
 }
 
 type FunctionDeclarationStatement struct {
-	Identifier  Token
-	Parameters  []Token
-	OutVariable *Token
-	Body        Statement
+	Identifier   Token
+	Parameters   []Token
+	OutVariables []Token
+	Body         Statement
 }
 
 func (s *FunctionDeclarationStatement) lineCol() LineCol {
@@ -100,6 +219,16 @@ func (s *AssignmentStatement) lineCol() LineCol {
 	return s.Identifier.LineCol()
 }
 
+type DestructuringAssignmentStatement struct {
+	Token       Token
+	Identifiers []Token
+	Expression  Expression
+}
+
+func (s *DestructuringAssignmentStatement) lineCol() LineCol {
+	return s.Token.LineCol()
+}
+
 type FieldAssignmentStatement struct {
 	Token      Token
 	Left       Expression
@@ -111,6 +240,19 @@ func (s *FieldAssignmentStatement) lineCol() LineCol {
 	return s.Token.LineCol()
 }
 
+// CompoundAssignmentStatement is a compound assignment to a container element or field, e.g. `[counts]key += 1`;
+// compound assignments to variables are parsed as regular assignments
+type CompoundAssignmentStatement struct {
+	Token      Token
+	Target     Expression // *ContainerAccessExpression or *FieldAccessExpression
+	Operator   Token      // The binary operator, e.g. '+' for '+='
+	Expression Expression
+}
+
+func (s *CompoundAssignmentStatement) lineCol() LineCol {
+	return s.Token.LineCol()
+}
+
 type ExpressionStatement struct {
 	Token      Token
 	Expression Expression
@@ -130,6 +272,27 @@ func (e *BinaryExpression) lineCol() LineCol {
 	return e.Operator.LineCol()
 }
 
+// ConditionalExpression is `then if condition otherwise otherwise`
+type ConditionalExpression struct {
+	Token     Token
+	Condition Expression
+	Then      Expression
+	Otherwise Expression
+}
+
+func (e *ConditionalExpression) lineCol() LineCol {
+	return e.Token.LineCol()
+}
+
+type UnaryExpression struct {
+	Operator Token
+	Operand  Expression
+}
+
+func (e *UnaryExpression) lineCol() LineCol {
+	return e.Operator.LineCol()
+}
+
 type FieldAccessExpression struct {
 	Token      Token
 	Left       Expression
@@ -140,6 +303,17 @@ func (e *FieldAccessExpression) lineCol() LineCol {
 	return e.Token.LineCol()
 }
 
+type MethodCallExpression struct {
+	Token     Token
+	Receiver  Expression
+	Method    Token
+	Arguments []Expression
+}
+
+func (e *MethodCallExpression) lineCol() LineCol {
+	return e.Token.LineCol()
+}
+
 type ContainerAccessExpression struct {
 	Token     Token
 	Container Expression
@@ -154,14 +328,35 @@ type FunctionCallExpression struct {
 	Token        Token
 	Builtin      bool
 	Constructor  bool
+	Variable     bool // Calls the function stored in the variable named FunctionName
 	FunctionName string
 	Arguments    []Expression
+	FieldIndexes []int // for constructor arguments that are not in field order: the field of each argument
 }
 
 func (e *FunctionCallExpression) lineCol() LineCol {
 	return e.Token.LineCol()
 }
 
+type FunctionReferenceExpression struct {
+	Token        Token
+	Builtin      bool
+	FunctionName string
+}
+
+func (e *FunctionReferenceExpression) lineCol() LineCol {
+	return e.Token.LineCol()
+}
+
+type FunctionLiteralExpression struct {
+	Token       Token
+	Declaration *FunctionDeclarationStatement
+}
+
+func (e *FunctionLiteralExpression) lineCol() LineCol {
+	return e.Token.LineCol()
+}
+
 type LiteralExpression struct {
 	Token Token
 }
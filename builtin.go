@@ -2,12 +2,14 @@ package main
 
 import (
 	"bytes"
+	"cmp"
 	"fmt"
-	"reflect"
+	"math/big"
 	"slices"
 	"sort"
 	"strconv"
 	"strings"
+	"unicode/utf8"
 )
 
 type BuiltinFunc func(Env, []Expression) (any, error)
@@ -23,7 +25,9 @@ const ArityVariadic = -1
 
 var builtins = map[string]Builtin{
 	"println":    {ArityVariadic, builtinPrintln, builtinPrintlnVm},
+	"raise":      {1, builtinRaise, builtinRaiseVm},
 	"inputLines": {0, builtinInputLines, builtinInputLinesVm},
+	"stdinLines": {0, builtinStdinLines, builtinStdinLinesVm},
 
 	"split": {2, builtinSplit, builtinSplitVm},
 	"chars": {1, builtinChars, builtinCharsVm},
@@ -31,10 +35,33 @@ var builtins = map[string]Builtin{
 	"int":    {1, builtinInt, builtinIntVm},
 	"string": {1, builtinString, builtinStringVm},
 
+	// Strings
+	"trim":       {1, builtinTrim, builtinTrimVm},
+	"startsWith": {2, builtinStartsWith, builtinStartsWithVm},
+	"endsWith":   {2, builtinEndsWith, builtinEndsWithVm},
+	"contains":   {2, builtinContains, builtinContainsVm},
+	"indexOf":    {2, builtinIndexOf, builtinIndexOfVm},
+	"substring":  {3, builtinSubstring, builtinSubstringVm},
+	"replace":    {3, builtinReplace, builtinReplaceVm},
+	"upper":      {1, builtinUpper, builtinUpperVm},
+	"lower":      {1, builtinLower, builtinLowerVm},
+	"repeat":     {2, builtinRepeat, builtinRepeatVm},
+	"join":       {2, builtinJoin, builtinJoinVm},
+	"padLeft":    {3, builtinPadLeft, builtinPadLeftVm},
+	"format":     {ArityVariadic, builtinFormat, builtinFormatVm},
+
+	// Regular expressions
+	"test":         {2, builtinTest, builtinTestVm},
+	"match":        {2, builtinMatch, builtinMatchVm},
+	"matchAll":     {2, builtinMatchAll, builtinMatchAllVm},
+	"findGroups":   {2, builtinFindGroups, builtinFindGroupsVm},
+	"replaceRegex": {3, builtinReplaceRegex, builtinReplaceRegexVm},
+
 	// "Arrays" and "Maps"
 	"array": {ArityVariadic, builtinArray, builtinArrayVm},
 	"map":   {ArityVariadic, builtinMap, builtinMapVm},
-	"get":   {2, builtinGet, builtinGetVm},
+	"get":   {ArityVariadic, builtinGet, builtinGetVm},
+	"slice": {3, builtinSlice, builtinSliceVm},
 	"push":  {2, builtinPush, builtinPushVm},
 	"pop":   {1, builtinPop, builtinPopVm},
 	"set":   {3, builtinSet, builtinSetVm},
@@ -42,7 +69,50 @@ var builtins = map[string]Builtin{
 	"keys":  {1, builtinKeys, builtinKeysVm},
 	"isSet": {2, builtinIsSet, builtinIsSetVm},
 	"unset": {2, builtinUnset, builtinUnsetVm},
-	"sort":  {1, builtinSort, builtinSortVm},
+	"sort":  {ArityVariadic, builtinSort, builtinSortVm},
+	"isNil": {1, builtinIsNil, builtinIsNilVm},
+	"tuple": {ArityVariadic, builtinTuple, builtinTupleVm},
+	"range": {ArityVariadic, builtinRange, builtinRangeVm},
+
+	// Sets
+	"newSet":       {0, builtinNewSet, builtinNewSetVm},
+	"toSet":        {1, builtinToSet, builtinToSetVm},
+	"add":          {2, builtinAdd, builtinAddVm},
+	"has":          {2, builtinHas, builtinHasVm},
+	"remove":       {2, builtinRemove, builtinRemoveVm},
+	"union":        {2, builtinUnion, builtinUnionVm},
+	"intersection": {2, builtinIntersection, builtinIntersectionVm},
+	"difference":   {2, builtinDifference, builtinDifferenceVm},
+
+	// Heaps
+	"heap":     {0, builtinHeap, builtinHeapVm},
+	"heapPush": {3, builtinHeapPush, builtinHeapPushVm},
+	"heapPop":  {1, builtinHeapPop, builtinHeapPopVm},
+	"heapPeek": {1, builtinHeapPeek, builtinHeapPeekVm},
+
+	// Types
+	"typeOf": {1, builtinTypeOf, builtinTypeOfVm},
+	"isType": {2, builtinIsType, builtinIsTypeVm},
+
+	// Math
+	"abs":    {1, builtinAbs, builtinAbsVm},
+	"sign":   {1, builtinSign, builtinSignVm},
+	"min":    {ArityVariadic, builtinMin, builtinMinVm},
+	"max":    {ArityVariadic, builtinMax, builtinMaxVm},
+	"sum":    {ArityVariadic, builtinSum, builtinSumVm},
+	"pow":    {2, builtinPow, builtinPowVm},
+	"modPow": {3, builtinModPow, builtinModPowVm},
+	"sqrt":   {1, builtinSqrt, builtinSqrtVm},
+	"gcd":    {2, builtinGcd, builtinGcdVm},
+	"lcm":    {2, builtinLcm, builtinLcmVm},
+
+	"toBinary": {2, builtinToBinary, builtinToBinaryVm},
+
+	// Higher-order functions
+	"mapArray": {2, builtinMapArray, builtinMapArrayVm},
+	"filter":   {2, builtinFilter, builtinFilterVm},
+	"reduce":   {3, builtinReduce, builtinReduceVm},
+	"sortBy":   {ArityVariadic, builtinSortBy, builtinSortByVm},
 }
 
 func toArguments(env Env, e []Expression) ([]any, error) {
@@ -83,10 +153,10 @@ type printer interface {
 func writeValue(v any, out *bytes.Buffer) {
 	if array, ok := v.(*[]any); ok {
 		writeArray(array, out)
-	} else if map_, ok := v.(*map[string]any); ok {
-		writeMap(map_, out)
 	} else if instance, ok := v.(printer); ok {
 		instance.print(out)
+	} else if v == nil {
+		out.WriteString("nil")
 	} else {
 		out.WriteString(fmt.Sprintf("%v", v))
 	}
@@ -103,19 +173,6 @@ func writeArray(array *[]any, out *bytes.Buffer) {
 	out.WriteRune(']')
 }
 
-func writeMap(map_ *map[string]any, out *bytes.Buffer) {
-	out.WriteRune('{')
-	for i, key := range sortedMapKeys(map_) {
-		if i != 0 {
-			out.WriteString(", ")
-		}
-		out.WriteString(fmt.Sprintf("%v", key))
-		out.WriteString(": ")
-		writeValue((*map_)[key], out)
-	}
-	out.WriteRune('}')
-}
-
 func builtinInputLines(env Env, e []Expression) (any, error) {
 	return toToiArray(strings.Split(strings.TrimSpace(toiStdin), "\n")), nil
 }
@@ -138,9 +195,9 @@ func builtinSplitVm(arguments []any) (any, error) {
 	var ok bool
 
 	if str, ok = maybeStr.(string); !ok {
-		return nil, fmt.Errorf("first argument needs to be a string, but was '%v'", maybeStr)
+		return nil, fmt.Errorf("first argument needs to be a string, but was '%v'", formatValue(maybeStr))
 	} else if sep, ok = maybeSep.(string); !ok {
-		return nil, fmt.Errorf("second argument needs to be a string, but was '%v'", maybeSep)
+		return nil, fmt.Errorf("second argument needs to be a string, but was '%v'", formatValue(maybeSep))
 	}
 
 	return toToiArray(strings.Split(str, sep)), nil
@@ -158,7 +215,7 @@ func builtinCharsVm(arguments []any) (any, error) {
 	v := arguments[0]
 	s, ok := v.(string)
 	if !ok {
-		return nil, fmt.Errorf("argument needs to be a string, but was '%v'", v)
+		return nil, fmt.Errorf("argument needs to be a string, but was '%v'", formatValue(v))
 	}
 
 	return toToiArray(strings.Split(s, "")), nil
@@ -173,12 +230,14 @@ func builtinString(env Env, e []Expression) (any, error) {
 }
 
 func builtinStringVm(arguments []any) (any, error) {
-	v := arguments[0]
-	if i, ok := v.(int); !ok {
-		return nil, fmt.Errorf("argument needs to be an int, but was '%v'", v)
-	} else {
-		return strconv.Itoa(i), nil
+	// string(v) converts any value to a string, as it would be printed
+	switch v := arguments[0].(type) {
+	case int:
+		return strconv.Itoa(v), nil
+	case string:
+		return v, nil
 	}
+	return formatValue(arguments[0]), nil
 }
 
 func builtinInt(env Env, e []Expression) (any, error) {
@@ -192,11 +251,15 @@ func builtinInt(env Env, e []Expression) (any, error) {
 func builtinIntVm(arguments []any) (any, error) {
 	v := arguments[0]
 	if s, ok := v.(string); !ok {
-		return nil, fmt.Errorf("argument needs to be a string, but was '%v'", v)
+		return nil, fmt.Errorf("argument needs to be a string, but was '%v'", formatValue(v))
 	} else {
 		i, err := strconv.Atoi(s)
 		if err != nil {
-			return nil, err
+			// Numbers that don't fit in an int are big ints
+			if b, ok := new(big.Int).SetString(s, 10); ok {
+				return normalizeBig(b), nil
+			}
+			return nil, fmt.Errorf("cannot convert '%s' to an int", s)
 		}
 		return i, nil
 	}
@@ -237,22 +300,17 @@ func builtinMapVm(arguments []any) (any, error) {
 		return nil, fmt.Errorf("map() argument count needs to be divisible by 2 but was %d", len(arguments))
 	}
 
-	map_ := make(map[string]any)
+	map_ := newToiMap()
 	for i := 0; i < len(arguments); i += 2 {
-		keyEntry := arguments[i]
-		value := arguments[i+1]
-
-		if key, ok := keyEntry.(string); ok {
-			map_[key] = value
-		} else {
-			return nil, fmt.Errorf("map() arguments need to alternate between string keys and any value; got '%v' in position %d instead of a string", reflect.TypeOf(keyEntry), i)
+		if err := map_.set(arguments[i], arguments[i+1]); err != nil {
+			return nil, fmt.Errorf("map() arguments need to alternate between keys and any value; got an invalid key in position %d: %w", i, err)
 		}
 	}
 
-	return &map_, nil
+	return map_, nil
 }
 
-func getSliceOrMapVm(arguments []any) (*[]any, *map[string]any, error) {
+func getSliceOrMapVm(arguments []any) (*[]any, *ToiMap, error) {
 	v := arguments[0]
 
 	array, ok := v.(*[]any)
@@ -260,55 +318,50 @@ func getSliceOrMapVm(arguments []any) (*[]any, *map[string]any, error) {
 		return array, nil, nil
 	}
 
-	map_, ok := v.(*map[string]any)
+	map_, ok := v.(*ToiMap)
 	if ok {
 		return nil, map_, nil
 	}
 
-	return nil, nil, fmt.Errorf("first argument needs to be an array or map, but was '%v'", v)
+	return nil, nil, fmt.Errorf("first argument needs to be an array or map, but was '%v'", formatValue(v))
 }
 
-func getArrayIndexVm(v any) (int, error) {
-	if i, ok := v.(int); ok {
-		return i, nil
-	} else {
-		return 0, fmt.Errorf("second argument needs to be a number, but was '%v'", v)
+// getArrayIndexVm returns the index into an array (or string or tuple) of the given length; negative indices count
+// from the end, so -1 is the last element. The index is not checked against the length.
+func getArrayIndexVm(v any, length int) (int, error) {
+	i, ok := v.(int)
+	if !ok {
+		return 0, fmt.Errorf("second argument needs to be a number, but was '%v'", formatValue(v))
+	}
+	if i < 0 {
+		i += length
 	}
+	return i, nil
 }
 
-func getMapKeyVm(v any) (string, error) {
-	if s, ok := v.(string); ok {
-		return s, nil
-	} else {
-		return "", fmt.Errorf("second argument needs to be a string, but was '%v'", v)
-	}
+func indexOutOfBoundsError(index any, length int) error {
+	return fmt.Errorf("index %v out of bounds (length %d)", formatValue(index), length)
 }
 
 func arrayOrMapOpVm(arguments []any,
 	sliceOp func(*[]any, int, []any) (any, error),
-	mapOp func(*map[string]any, string, []any) (any, error)) (any, error) {
+	mapOp func(*ToiMap, any, []any) (any, error)) (any, error) {
 	slice, map_, err := getSliceOrMapVm(arguments)
 	if err != nil {
 		return nil, err
 	} else if slice != nil {
-		idx, err := getArrayIndexVm(arguments[1])
+		idx, err := getArrayIndexVm(arguments[1], len(*slice))
 		if err != nil {
 			return nil, err
 		}
 
 		return sliceOp(slice, idx, arguments)
 	} else {
-		key, err := getMapKeyVm(arguments[1])
-		if err != nil {
-			return nil, err
-		}
-
-		return mapOp(map_, key, arguments)
+		return mapOp(map_, arguments[1], arguments)
 	}
 }
 
 func builtinGet(env Env, e []Expression) (any, error) {
-	// get(arr, 2) or get(arr, "hello")
 	arguments, err := toArguments(env, e)
 	if err != nil {
 		return nil, err
@@ -317,22 +370,147 @@ func builtinGet(env Env, e []Expression) (any, error) {
 }
 
 func builtinGetVm(arguments []any) (any, error) {
-	// get(arr, 2) or get(arr, "hello")
+	// get(arr, 2), get(map, "hello"), or get(map, "hello", default)
+	if len(arguments) != 2 && len(arguments) != 3 {
+		return nil, fmt.Errorf("get() takes 2 or 3 arguments, but got %d", len(arguments))
+	}
+	hasDefault := len(arguments) == 3
+
+	if tuple, ok := arguments[0].(*Tuple); ok {
+		// get(tuple, 1)
+		idx, err := getArrayIndexVm(arguments[1], len(tuple.values))
+		if err != nil {
+			return nil, err
+		}
+		if idx < 0 || idx >= len(tuple.values) {
+			if hasDefault {
+				return arguments[2], nil
+			}
+			return nil, indexOutOfBoundsError(arguments[1], len(tuple.values))
+		}
+		return tuple.values[idx], nil
+	}
+	if s, ok := arguments[0].(string); ok {
+		// get(s, 1) is the second character (rune) of the string
+		runes := []rune(s)
+		idx, err := getArrayIndexVm(arguments[1], len(runes))
+		if err != nil {
+			return nil, err
+		}
+		if idx < 0 || idx >= len(runes) {
+			if hasDefault {
+				return arguments[2], nil
+			}
+			return nil, indexOutOfBoundsError(arguments[1], len(runes))
+		}
+		return string(runes[idx]), nil
+	}
+	if s, ok := arguments[0].(*ToiSet); ok {
+		// get(set, v) is v if it is in the set, so iterating over a set works like iterating over its keys
+		found, err := s.has(arguments[1])
+		if err != nil || found {
+			return arguments[1], err
+		} else if hasDefault {
+			return arguments[2], nil
+		}
+		return nil, nil
+	}
 	return arrayOrMapOpVm(arguments,
 		func(slice *[]any, idx int, arguments []any) (any, error) {
 			// get(arr, 2)
 			s := *slice
-			if idx >= len(s) {
-				return nil, fmt.Errorf("index out of bounds (requested %d; length %d)", idx, len(s))
+			if idx < 0 || idx >= len(s) {
+				if hasDefault {
+					return arguments[2], nil
+				}
+				return nil, indexOutOfBoundsError(arguments[1], len(s))
 			}
 			return s[idx], nil
-		}, func(map_ *map[string]any, key string, arguments []any) (any, error) {
-			// get(arr, "hello")
-			return (*map_)[key], nil
+		}, func(map_ *ToiMap, key any, arguments []any) (any, error) {
+			// get(map, "hello"); a key that is not set is nil, unless a default is given
+			v, found, err := map_.get(key)
+			if !found && hasDefault && err == nil {
+				return arguments[2], nil
+			}
+			return v, err
 		},
 	)
 }
 
+func builtinIsNil(env Env, e []Expression) (any, error) {
+	arguments, err := toArguments(env, e)
+	if err != nil {
+		return nil, err
+	}
+	return builtinIsNilVm(arguments)
+}
+
+func builtinIsNilVm(arguments []any) (any, error) {
+	// isNil(v)
+	return boolToInt(arguments[0] == nil), nil
+}
+
+func builtinSlice(env Env, e []Expression) (any, error) {
+	arguments, err := toArguments(env, e)
+	if err != nil {
+		return nil, err
+	}
+	return builtinSliceVm(arguments)
+}
+
+func builtinSliceVm(arguments []any) (any, error) {
+	// slice(arr, 1, 4), or slice(s, 0, 3); nil as start or end means the start or end of the array or string
+	switch v := arguments[0].(type) {
+	case *[]any:
+		start, end, err := getSliceRangeVm(arguments, len(*v))
+		if err != nil {
+			return nil, err
+		}
+		result := slices.Clone((*v)[start:end])
+		return &result, nil
+	case *Tuple:
+		start, end, err := getSliceRangeVm(arguments, len(v.values))
+		if err != nil {
+			return nil, err
+		}
+		return &Tuple{values: slices.Clone(v.values[start:end])}, nil
+	case string:
+		runes := []rune(v)
+		start, end, err := getSliceRangeVm(arguments, len(runes))
+		if err != nil {
+			return nil, err
+		}
+		return string(runes[start:end]), nil
+	}
+	return nil, fmt.Errorf("first argument needs to be an array, tuple, or string, but was '%v'", formatValue(arguments[0]))
+}
+
+func getSliceRangeVm(arguments []any, length int) (int, int, error) {
+	start, end := 0, length
+	var err error
+	if arguments[1] != nil {
+		if start, err = getArrayIndexVm(arguments[1], length); err != nil {
+			return 0, 0, err
+		}
+	}
+	if arguments[2] != nil {
+		if end, err = getArrayIndexVm(arguments[2], length); err != nil {
+			return 0, 0, fmt.Errorf("third argument needs to be a number, but was '%v'", formatValue(arguments[2]))
+		}
+	}
+	if start < 0 || end > length || start > end {
+		return 0, 0, fmt.Errorf("range %s..%s out of bounds (length %d)", formatRangeBound(arguments[1]), formatRangeBound(arguments[2]), length)
+	}
+	return start, end, nil
+}
+
+func formatRangeBound(v any) string {
+	if v == nil {
+		return ""
+	}
+	return formatValue(v)
+}
+
 func builtinPush(env Env, e []Expression) (any, error) {
 	// push(arr, 42)
 	arguments, err := toArguments(env, e)
@@ -347,7 +525,7 @@ func builtinPushVm(arguments []any) (any, error) {
 	arr := arguments[0]
 	array, ok := arr.(*[]any)
 	if !ok {
-		return nil, fmt.Errorf("first argument needs to be an array, but was '%v'", arr)
+		return nil, fmt.Errorf("first argument needs to be an array, but was '%v'", formatValue(arr))
 	}
 
 	v := arguments[1]
@@ -369,7 +547,7 @@ func builtinPopVm(arguments []any) (any, error) {
 	arr := arguments[0]
 	array, ok := arr.(*[]any)
 	if !ok {
-		return nil, fmt.Errorf("first argument needs to be an array, but was '%v'", arr)
+		return nil, fmt.Errorf("first argument needs to be an array, but was '%v'", formatValue(arr))
 	}
 
 	last := len(*array) - 1
@@ -379,7 +557,6 @@ func builtinPopVm(arguments []any) (any, error) {
 }
 
 func builtinSet(env Env, e []Expression) (any, error) {
-	// set(arr, 2, 42) or set(map, "hello", 42)
 	arguments, err := toArguments(env, e)
 	if err != nil {
 		return nil, err
@@ -389,23 +566,25 @@ func builtinSet(env Env, e []Expression) (any, error) {
 
 func builtinSetVm(arguments []any) (any, error) {
 	// set(arr, 2, 42) or set(map, "hello", 42)
+	if _, ok := arguments[0].(*Tuple); ok {
+		return nil, fmt.Errorf("cannot set an element of tuple '%s'; tuples are read-only", formatValue(arguments[0]))
+	}
 	return arrayOrMapOpVm(arguments,
 		func(slice *[]any, idx int, arguments []any) (any, error) {
 			// set(arr, 2, 42)
 			v := arguments[2]
 			if idx == len(*slice) {
 				*slice = append(*slice, v)
-			} else if idx < len(*slice) {
+			} else if idx >= 0 && idx < len(*slice) {
 				(*slice)[idx] = v
 			} else {
-				return nil, fmt.Errorf("index %d out of bounds (length %d)", idx, len(*slice))
+				return nil, indexOutOfBoundsError(arguments[1], len(*slice))
 			}
 			return v, nil
-		}, func(map_ *map[string]any, key string, arguments []any) (any, error) {
-			// set(arr, "hello", 42)
+		}, func(map_ *ToiMap, key any, arguments []any) (any, error) {
+			// set(map, "hello", 42)
 			v := arguments[2]
-			(*map_)[key] = v
-			return v, nil
+			return v, map_.set(key, v)
 		},
 	)
 }
@@ -421,13 +600,22 @@ func builtinLen(env Env, e []Expression) (any, error) {
 
 func builtinLenVm(arguments []any) (any, error) {
 	// len(arr)
+	if tuple, ok := arguments[0].(*Tuple); ok {
+		return len(tuple.values), nil
+	} else if s, ok := arguments[0].(string); ok {
+		return utf8.RuneCountInString(s), nil
+	} else if s, ok := arguments[0].(*ToiSet); ok {
+		return s.elements.len(), nil
+	} else if h, ok := arguments[0].(*ToiHeap); ok {
+		return h.entries.Len(), nil
+	}
 	slice, map_, err := getSliceOrMapVm(arguments)
 	if err != nil {
 		return nil, err
 	} else if slice != nil {
 		return len(*slice), nil
 	} else {
-		return len(*map_), nil
+		return map_.len(), nil
 	}
 }
 
@@ -442,13 +630,19 @@ func builtinKeys(env Env, e []Expression) (any, error) {
 
 func builtinKeysVm(arguments []any) (any, error) {
 	// keys(map)
+	if tuple, ok := arguments[0].(*Tuple); ok {
+		return indexes(&tuple.values), nil
+	} else if s, ok := arguments[0].(*ToiSet); ok {
+		// The keys of a set are its elements
+		return toToiArray(s.elements.sortedKeys()), nil
+	}
 	slice, map_, err := getSliceOrMapVm(arguments)
 	if err != nil {
 		return nil, err
 	} else if slice != nil {
 		return indexes(slice), nil
 	} else {
-		return keys(map_), nil
+		return toToiArray(map_.sortedKeys()), nil
 	}
 }
 
@@ -460,20 +654,6 @@ func indexes(array *[]any) *[]any {
 	return toToiArray(indexes)
 }
 
-func keys(map_ *map[string]any) *[]any {
-	return toToiArray(sortedMapKeys(map_))
-}
-
-func sortedMapKeys(map_ *map[string]any) []string {
-	keys := make([]string, 0)
-	for key := range *map_ {
-		keys = append(keys, key)
-	}
-	// Sorting only to get consistent test results between invocations
-	slices.Sort(keys)
-	return keys
-}
-
 func builtinIsSet(env Env, e []Expression) (any, error) {
 	// isSet(map, "key")
 	arguments, err := toArguments(env, e)
@@ -490,16 +670,11 @@ func builtinIsSetVm(arguments []any) (any, error) {
 		return nil, err
 	}
 
-	key, err := getMapKeyVm(arguments[1])
+	_, found, err := map_.get(arguments[1])
 	if err != nil {
 		return nil, err
 	}
-
-	if _, found := (*map_)[key]; found {
-		return 1, nil
-	} else {
-		return 0, nil
-	}
+	return boolToInt(found), nil
 }
 
 func builtinUnset(env Env, e []Expression) (any, error) {
@@ -518,17 +693,10 @@ func builtinUnsetVm(arguments []any) (any, error) {
 		return nil, err
 	}
 
-	key, err := getMapKeyVm(arguments[1])
-	if err != nil {
-		return nil, err
-	}
-
-	delete(*map_, key)
-	return 0, nil
+	return 0, map_.remove(arguments[1])
 }
 
 func builtinSort(env Env, e []Expression) (any, error) {
-	// sort(arr)
 	arguments, err := toArguments(env, e)
 	if err != nil {
 		return nil, err
@@ -537,85 +705,174 @@ func builtinSort(env Env, e []Expression) (any, error) {
 }
 
 func builtinSortVm(arguments []any) (any, error) {
-	v := arguments[0]
+	// sort(arr), sort(arr, "desc"), sort(arr, keyFunction), or sort(arr, keyFunction, "desc")
+	if len(arguments) < 1 || len(arguments) > 3 {
+		return nil, fmt.Errorf("sort() takes 1 to 3 arguments, but got %d", len(arguments))
+	}
 
+	v := arguments[0]
 	array, ok := v.(*[]any)
 	if !ok {
-		return nil, fmt.Errorf("argument to sort() needs to be an array, but was '%v'", v)
+		return nil, fmt.Errorf("argument to sort() needs to be an array, but was '%v'", formatValue(v))
 	}
 
-	sort.Slice(*array, func(i, j int) bool {
-		return less((*array)[i], (*array)[j])
-	})
-	return nil, nil
-}
+	options := arguments[1:]
+	var keyFunction callable
+	if len(options) != 0 {
+		if keyFunction, ok = options[0].(callable); ok {
+			options = options[1:]
+		}
+	}
 
-func lessToiInstance(left, right *ToiInstance) bool {
-	if left.toiType.Identifier.Lexeme != right.toiType.Identifier.Lexeme {
-		return left.toiType.Identifier.Lexeme < right.toiType.Identifier.Lexeme
+	descending := false
+	if len(options) == 1 {
+		var err error
+		descending, err = getSortOrderVm(options[0])
+		if err != nil {
+			return nil, err
+		}
+	} else if len(options) > 1 {
+		return nil, fmt.Errorf("sort() options need to be a key function and/or sort order, but got '%v'", formatValue(options[0]))
 	}
-	for i, lv := range left.fieldValues {
-		rv := right.fieldValues[i]
-		if less(lv, rv) {
-			return true
-		} else {
-			return false
+
+	// Values are their own keys; cloned, because sortByKeys swaps both the values and the keys
+	keys := slices.Clone(*array)
+	if keyFunction != nil {
+		var err error
+		keys, err = sortKeys(*array, keyFunction.call)
+		if err != nil {
+			return nil, err
 		}
 	}
-	return false
+
+	return nil, sortByKeys(*array, keys, descending)
 }
 
-func lessVmInstance(left, right *VmInstance) bool {
-	if left.vmType.Name != right.vmType.Name {
-		return left.vmType.Name < right.vmType.Name
+func getSortOrderVm(v any) (bool, error) {
+	if v == "asc" {
+		return false, nil
+	} else if v == "desc" {
+		return true, nil
 	}
-	for i, lv := range left.values {
-		rv := right.values[i]
-		if less(lv, rv) {
-			return true
-		} else {
-			return false
+	return false, fmt.Errorf("sort order needs to be \"asc\" or \"desc\", but was '%v'", formatValue(v))
+}
+
+// sortKeys determines the sort key for every value up front, so the key function is called exactly once for each
+func sortKeys(values []any, keyFunction func([]any) (any, error)) ([]any, error) {
+	keys := make([]any, len(values))
+	for i, v := range values {
+		key, err := keyFunction([]any{v})
+		if err != nil {
+			return nil, err
 		}
+		keys[i] = key
+	}
+	return keys, nil
+}
+
+// sortByKeys stably sorts the values (in place) by their respective keys
+func sortByKeys(values []any, keys []any, descending bool) error {
+	keyedSort := &keyedSort{values: values, keys: keys, descending: descending}
+	sort.Stable(keyedSort)
+	return keyedSort.err
+}
+
+// keyedSort sorts values by their keys; the first error that occurs when comparing keys is kept in err
+type keyedSort struct {
+	values     []any
+	keys       []any
+	descending bool
+	err        error
+}
+
+func (s *keyedSort) Len() int {
+	return len(s.values)
+}
+
+func (s *keyedSort) Less(i, j int) bool {
+	c, err := compare(s.keys[i], s.keys[j])
+	if err != nil && s.err == nil {
+		s.err = err
+	}
+	if s.descending {
+		return c > 0
 	}
-	return false
+	return c < 0
+}
+
+func (s *keyedSort) Swap(i, j int) {
+	s.values[i], s.values[j] = s.values[j], s.values[i]
+	s.keys[i], s.keys[j] = s.keys[j], s.keys[i]
 }
 
-func less(l, r any) bool {
-	ls, lsok := l.(string)
-	rs, rsok := r.(string)
-	if lsok && rsok {
-		return ls < rs
+func compareToiInstance(left, right *ToiInstance) (int, error) {
+	if left.toiType != right.toiType {
+		return 0, fmt.Errorf("cannot compare '%v' with '%v' of a different type", formatValue(left), formatValue(right))
 	}
-	li, liok := l.(int)
-	ri, riok := r.(int)
-	if liok && riok {
-		return li < ri
+	for i, lv := range left.fieldValues {
+		if c, err := compare(lv, right.fieldValues[i]); c != 0 || err != nil {
+			return c, err
+		}
 	}
-	if liok && rsok {
-		// special case: ints go before strings
-		return true
+	return 0, nil
+}
+
+func compareVmInstance(left, right *VmInstance) (int, error) {
+	if left.vmType.Name != right.vmType.Name {
+		return 0, fmt.Errorf("cannot compare '%v' with '%v' of a different type", formatValue(left), formatValue(right))
 	}
-	lt, ltok := l.(*ToiInstance)
-	rt, rtok := r.(*ToiInstance)
-	if ltok && rtok {
-		return lessToiInstance(lt, rt)
+	for i, lv := range left.values {
+		if c, err := compare(lv, right.values[i]); c != 0 || err != nil {
+			return c, err
+		}
 	}
-	lv, lvok := l.(*VmInstance)
-	rv, rvok := r.(*VmInstance)
-	if lvok && rvok {
-		return lessVmInstance(lv, rv)
+	return 0, nil
+}
+
+// compare returns a negative number if l < r, 0 if l == r, and a positive number if l > r; only ints, strings, tuples
+// (element by element), and instances of the same type (field by field, in order of declaration) can be compared
+func compare(l, r any) (int, error) {
+	switch left := l.(type) {
+	case int, *big.Int:
+		if isNumber(r) {
+			return cmpNumbers(left, r), nil
+		}
+	case string:
+		if right, ok := r.(string); ok {
+			return cmp.Compare(left, right), nil
+		}
+	case *ToiInstance:
+		if right, ok := r.(*ToiInstance); ok {
+			return compareToiInstance(left, right)
+		}
+	case *VmInstance:
+		if right, ok := r.(*VmInstance); ok {
+			return compareVmInstance(left, right)
+		}
+	case *Tuple:
+		if right, ok := r.(*Tuple); ok {
+			return compareTuple(left, right)
+		}
+	default:
+		return 0, fmt.Errorf("cannot compare '%v'; only ints, strings, tuples, and custom types can be compared", formatValue(l))
 	}
-	return false
+	return 0, fmt.Errorf("cannot compare '%v' with '%v' of a different type", formatValue(l), formatValue(r))
+}
+
+func formatValue(v any) string {
+	out := &bytes.Buffer{}
+	writeValue(v, out)
+	return out.String()
 }
 
-func getMapVm(arguments []any) (*map[string]any, error) {
+func getMapVm(arguments []any) (*ToiMap, error) {
 	v := arguments[0]
 
-	map_, ok := v.(*map[string]any)
+	map_, ok := v.(*ToiMap)
 	if ok {
 		return map_, nil
 	}
-	return nil, fmt.Errorf("first argument needs to be a map, but was '%v'", v)
+	return nil, fmt.Errorf("first argument needs to be a map, but was '%v'", formatValue(v))
 }
 
 func toToiArray[T any](l []T) *[]any {
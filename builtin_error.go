@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+)
+
+// ToiError is an error value, caught by a failure block: either a runtime error, or a value passed to raise(). It
+// has the position of the statement that failed
+type ToiError struct {
+	message string
+	value   any // the value passed to raise(), or the message for runtime errors
+	line    int
+	col     int
+}
+
+func (e *ToiError) Error() string {
+	return e.message
+}
+
+func (e *ToiError) print(out *bytes.Buffer) {
+	out.WriteString(fmt.Sprintf("error at %d:%d: %s", e.line, e.col, e.message))
+}
+
+// field returns the value of err.message, err.value, err.line or err.col
+func (e *ToiError) field(name string) (any, error) {
+	switch name {
+	case "message":
+		return e.message, nil
+	case "value":
+		return e.value, nil
+	case "line":
+		return e.line, nil
+	case "col":
+		return e.col, nil
+	}
+	return nil, fmt.Errorf("field '%v' not found on type 'error'", name)
+}
+
+// toToiError turns a runtime error into an error value at the given position; errors that already are error values
+// keep the position they have, so that they are reported where they happened
+func toToiError(err error, lineCol LineCol) *ToiError {
+	var toiError *ToiError
+	if !errors.As(err, &toiError) {
+		toiError = &ToiError{message: err.Error(), value: err.Error()}
+	}
+	if toiError.line == 0 {
+		// Raised errors get the position of the statement that raised them
+		toiError.line, toiError.col = lineCol.line, lineCol.col
+	}
+	return toiError
+}
+
+// undefinedVariableError and notAFunctionError are runtime errors of both the interpreter and the VM, which have the
+// same message in both so that a failure block sees the same error value
+func undefinedVariableError(name string) error {
+	return fmt.Errorf("undefined variable '%s'", name)
+}
+
+func notAFunctionError(value any) error {
+	return fmt.Errorf("cannot call '%v', because it is not a function", formatValue(value))
+}
+
+func builtinRaise(env Env, e []Expression) (any, error) {
+	arguments, err := toArguments(env, e)
+	if err != nil {
+		return nil, err
+	}
+	return builtinRaiseVm(arguments)
+}
+
+func builtinRaiseVm(arguments []any) (any, error) {
+	// raise(value); raising a caught error again keeps its original message and position
+	if toiError, ok := arguments[0].(*ToiError); ok {
+		return nil, toiError
+	}
+	message, ok := arguments[0].(string)
+	if !ok {
+		message = formatValue(arguments[0])
+	}
+	return nil, &ToiError{message: message, value: arguments[0]}
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// callable is implemented by function values, so builtins can call back into user code in both the interpreter and
+// the VM
+type callable interface {
+	call(arguments []any) (any, error)
+}
+
+// BuiltinFunctionValue is a reference to a builtin function, e.g. &len
+type BuiltinFunctionValue struct {
+	name    string
+	builtin Builtin
+}
+
+func (f *BuiltinFunctionValue) call(arguments []any) (any, error) {
+	if f.builtin.Arity != ArityVariadic && len(arguments) != f.builtin.Arity {
+		return nil, fmt.Errorf("expected %d arguments but got %d for function '%s'", f.builtin.Arity, len(arguments), f.name)
+	}
+	return f.builtin.VmFunc(arguments)
+}
+
+func (f *BuiltinFunctionValue) print(out *bytes.Buffer) {
+	printFunction(f.name, out)
+}
+
+// BoundMethod is a method of a custom type together with the instance it was taken from, e.g. item.describe
+type BoundMethod struct {
+	name     string
+	receiver any
+	method   callable
+}
+
+func (m *BoundMethod) call(arguments []any) (any, error) {
+	return m.method.call(append([]any{m.receiver}, arguments...))
+}
+
+func (m *BoundMethod) print(out *bytes.Buffer) {
+	out.WriteString("<method " + m.name + ">")
+}
+
+func printFunction(name string, out *bytes.Buffer) {
+	if strings.HasPrefix(name, anonymousFunctionPrefix) {
+		out.WriteString("<anonymous function>")
+	} else {
+		out.WriteString("<function " + name + ">")
+	}
+}
+
+func getArrayAndFunctionVm(arguments []any) (*[]any, callable, error) {
+	v := arguments[0]
+	array, ok := v.(*[]any)
+	if !ok {
+		return nil, nil, fmt.Errorf("first argument needs to be an array, but was '%v'", formatValue(v))
+	}
+
+	f := arguments[1]
+	function, ok := f.(callable)
+	if !ok {
+		return nil, nil, fmt.Errorf("second argument needs to be a function, but was '%v'", formatValue(f))
+	}
+	return array, function, nil
+}
+
+func builtinMapArray(env Env, e []Expression) (any, error) {
+	arguments, err := toArguments(env, e)
+	if err != nil {
+		return nil, err
+	}
+	return builtinMapArrayVm(arguments)
+}
+
+func builtinMapArrayVm(arguments []any) (any, error) {
+	// mapArray(arr, |v| r { r = v * 2 })
+	array, function, err := getArrayAndFunctionVm(arguments)
+	if err != nil {
+		return nil, err
+	}
+
+	mapped := make([]any, len(*array))
+	for i, v := range *array {
+		mapped[i], err = function.call([]any{v})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &mapped, nil
+}
+
+func builtinFilter(env Env, e []Expression) (any, error) {
+	arguments, err := toArguments(env, e)
+	if err != nil {
+		return nil, err
+	}
+	return builtinFilterVm(arguments)
+}
+
+func builtinFilterVm(arguments []any) (any, error) {
+	// filter(arr, |v| r { r = v > 2 })
+	array, function, err := getArrayAndFunctionVm(arguments)
+	if err != nil {
+		return nil, err
+	}
+
+	filtered := make([]any, 0)
+	for _, v := range *array {
+		keep, err := function.call([]any{v})
+		if err != nil {
+			return nil, err
+		}
+		if isWeirdlyTrue(keep) {
+			filtered = append(filtered, v)
+		}
+	}
+	return &filtered, nil
+}
+
+func builtinReduce(env Env, e []Expression) (any, error) {
+	arguments, err := toArguments(env, e)
+	if err != nil {
+		return nil, err
+	}
+	return builtinReduceVm(arguments)
+}
+
+func builtinReduceVm(arguments []any) (any, error) {
+	// reduce(arr, |acc v| r { r = acc + v }, 0)
+	array, function, err := getArrayAndFunctionVm(arguments)
+	if err != nil {
+		return nil, err
+	}
+
+	accumulator := arguments[2]
+	for _, v := range *array {
+		accumulator, err = function.call([]any{accumulator, v})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return accumulator, nil
+}
+
+func builtinSortBy(env Env, e []Expression) (any, error) {
+	arguments, err := toArguments(env, e)
+	if err != nil {
+		return nil, err
+	}
+	return builtinSortByVm(arguments)
+}
+
+func builtinSortByVm(arguments []any) (any, error) {
+	// sortBy(arr, |v| key { key = v.name }) or sortBy(arr, "fieldName"), optionally followed by "desc"
+	if len(arguments) != 2 && len(arguments) != 3 {
+		return nil, fmt.Errorf("sortBy() takes 2 or 3 arguments, but got %d", len(arguments))
+	}
+
+	v := arguments[0]
+	array, ok := v.(*[]any)
+	if !ok {
+		return nil, fmt.Errorf("first argument needs to be an array, but was '%v'", formatValue(v))
+	}
+
+	var keyFunction func([]any) (any, error)
+	if function, ok := arguments[1].(callable); ok {
+		keyFunction = function.call
+	} else if fieldName, ok := arguments[1].(string); ok {
+		keyFunction = func(arguments []any) (any, error) {
+			return getFieldValue(arguments[0], fieldName)
+		}
+	} else {
+		return nil, fmt.Errorf("second argument needs to be a function or field name, but was '%v'", formatValue(arguments[1]))
+	}
+
+	descending := false
+	if len(arguments) == 3 {
+		var err error
+		descending, err = getSortOrderVm(arguments[2])
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	keys, err := sortKeys(*array, keyFunction)
+	if err != nil {
+		return nil, err
+	}
+	return nil, sortByKeys(*array, keys, descending)
+}
+
+// getFieldValue returns the value of a field of a custom type instance, from either the interpreter or the VM
+func getFieldValue(v any, fieldName string) (any, error) {
+	if instance, ok := v.(*ToiInstance); ok {
+		index, found := instance.toiType.FieldMap[fieldName]
+		if !found {
+			return nil, fmt.Errorf("field '%v' not found on type '%v'", fieldName, instance.toiType.Identifier.Lexeme)
+		}
+		return instance.fieldValues[index], nil
+	} else if instance, ok := v.(*VmInstance); ok {
+		index, found := instance.vmType.FieldMap[fieldName]
+		if !found {
+			return nil, fmt.Errorf("field '%v' not found on type '%v'", fieldName, instance.vmType.Name)
+		}
+		return instance.values[index], nil
+	}
+	return nil, fmt.Errorf("cannot get field '%v' of '%v' because it is not a custom type instance", fieldName, formatValue(v))
+}
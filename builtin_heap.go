@@ -0,0 +1,222 @@
+package main
+
+import (
+	"bytes"
+	"container/heap"
+	"fmt"
+	"math/big"
+	"strconv"
+)
+
+// ToiHeap is a priority queue which pops the value with the lowest priority first; values with the same priority
+// are popped in the order they were pushed
+type ToiHeap struct {
+	entries heapEntries
+	pushed  int
+	shape   *priorityShape // of all priorities in the heap, so a priority that cannot be compared is never added
+}
+
+type heapEntry struct {
+	priority any
+	value    any
+	order    int
+}
+
+// heapEntries implements heap.Interface; the first error that occurs when comparing priorities is kept in err, which
+// is reset before every operation
+type heapEntries struct {
+	entries []heapEntry
+	err     error
+}
+
+func (h *heapEntries) Len() int {
+	return len(h.entries)
+}
+
+func (h *heapEntries) Less(i, j int) bool {
+	c, err := compare(h.entries[i].priority, h.entries[j].priority)
+	if err != nil && h.err == nil {
+		h.err = err
+	}
+	if c == 0 {
+		return h.entries[i].order < h.entries[j].order
+	}
+	return c < 0
+}
+
+func (h *heapEntries) Swap(i, j int) {
+	h.entries[i], h.entries[j] = h.entries[j], h.entries[i]
+}
+
+func (h *heapEntries) Push(x any) {
+	h.entries = append(h.entries, x.(heapEntry))
+}
+
+func (h *heapEntries) Pop() any {
+	last := len(h.entries) - 1
+	entry := h.entries[last]
+	h.entries = h.entries[:last]
+	return entry
+}
+
+// priorityShape is the types that make up heap priorities: an int or string, or the types of the elements of tuples
+// and the fields of custom types. Priorities with compatible shapes can always be compared with each other.
+type priorityShape struct {
+	kind     string // "int", "string", "tuple", or the name of a custom type
+	elements []*priorityShape
+}
+
+var (
+	intShape    = &priorityShape{kind: "int"}
+	stringShape = &priorityShape{kind: "string"}
+)
+
+func newPriorityShape(v any) (*priorityShape, error) {
+	switch value := v.(type) {
+	case int, *big.Int:
+		return intShape, nil
+	case string:
+		return stringShape, nil
+	case *Tuple:
+		return newCompositeShape("tuple", value.values)
+	case *ToiInstance:
+		return newCompositeShape(typeName(v), value.fieldValues)
+	case *VmInstance:
+		return newCompositeShape(typeName(v), value.values)
+	}
+	return nil, fmt.Errorf("cannot use '%v' as a priority; only ints, strings, tuples, and custom types can be compared", formatValue(v))
+}
+
+func newCompositeShape(kind string, values []any) (*priorityShape, error) {
+	shape := &priorityShape{kind: kind, elements: make([]*priorityShape, len(values))}
+	for i, v := range values {
+		element, err := newPriorityShape(v)
+		if err != nil {
+			return nil, err
+		}
+		shape.elements[i] = element
+	}
+	return shape, nil
+}
+
+// isCompatible returns whether all values of both shapes can be compared; tuples are compared up to the length of
+// the shorter one, so they can have different lengths
+func (s *priorityShape) isCompatible(other *priorityShape) bool {
+	if s.kind != other.kind {
+		return false
+	}
+	for i := 0; i < len(s.elements) && i < len(other.elements); i++ {
+		if !s.elements[i].isCompatible(other.elements[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// merge adds the elements of a compatible shape that this shape doesn't have yet, i.e. of longer tuples
+func (s *priorityShape) merge(other *priorityShape) {
+	for i, element := range other.elements {
+		if i < len(s.elements) {
+			s.elements[i].merge(element)
+		} else {
+			s.elements = append(s.elements, element)
+		}
+	}
+}
+
+func (h *ToiHeap) print(out *bytes.Buffer) {
+	out.WriteString("<heap with " + strconv.Itoa(h.entries.Len()) + " values>")
+}
+
+func getHeapVm(arguments []any) (*ToiHeap, error) {
+	v := arguments[0]
+	h, ok := v.(*ToiHeap)
+	if !ok {
+		return nil, fmt.Errorf("first argument needs to be a heap, but was '%v'", formatValue(v))
+	}
+	return h, nil
+}
+
+func builtinHeap(env Env, e []Expression) (any, error) {
+	return builtinHeapVm(nil)
+}
+
+func builtinHeapVm(arguments []any) (any, error) {
+	// heap()
+	return &ToiHeap{}, nil
+}
+
+func builtinHeapPush(env Env, e []Expression) (any, error) {
+	arguments, err := toArguments(env, e)
+	if err != nil {
+		return nil, err
+	}
+	return builtinHeapPushVm(arguments)
+}
+
+func builtinHeapPushVm(arguments []any) (any, error) {
+	// heapPush(h, priority, value)
+	h, err := getHeapVm(arguments)
+	if err != nil {
+		return nil, err
+	}
+
+	// Checked before anything changes, so the heap stays as it was when a priority cannot be compared
+	priority, value := arguments[1], arguments[2]
+	shape, err := newPriorityShape(priority)
+	if err != nil {
+		return nil, fmt.Errorf("invalid heap priority: %w", err)
+	}
+	if h.entries.Len() == 0 {
+		h.shape = shape
+	} else if !h.shape.isCompatible(shape) {
+		return nil, fmt.Errorf("invalid heap priority: cannot compare '%v' with the other priorities, like '%v'", formatValue(priority), formatValue(h.entries.entries[0].priority))
+	} else if len(shape.elements) != 0 {
+		h.shape.merge(shape)
+	}
+
+	h.entries.err = nil
+	heap.Push(&h.entries, heapEntry{priority: priority, value: value, order: h.pushed})
+	h.pushed++
+	return value, h.entries.err
+}
+
+func builtinHeapPop(env Env, e []Expression) (any, error) {
+	arguments, err := toArguments(env, e)
+	if err != nil {
+		return nil, err
+	}
+	return builtinHeapPopVm(arguments)
+}
+
+func builtinHeapPopVm(arguments []any) (any, error) {
+	// heapPop(h)
+	h, err := getHeapVm(arguments)
+	if err != nil {
+		return nil, err
+	} else if h.entries.Len() == 0 {
+		return nil, fmt.Errorf("cannot pop from an empty heap")
+	}
+	h.entries.err = nil
+	entry := heap.Pop(&h.entries).(heapEntry)
+	return entry.value, h.entries.err
+}
+
+func builtinHeapPeek(env Env, e []Expression) (any, error) {
+	arguments, err := toArguments(env, e)
+	if err != nil {
+		return nil, err
+	}
+	return builtinHeapPeekVm(arguments)
+}
+
+func builtinHeapPeekVm(arguments []any) (any, error) {
+	// heapPeek(h)
+	h, err := getHeapVm(arguments)
+	if err != nil {
+		return nil, err
+	} else if h.entries.Len() == 0 {
+		return nil, fmt.Errorf("cannot peek into an empty heap")
+	}
+	return h.entries.entries[0].value, nil
+}
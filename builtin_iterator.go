@@ -0,0 +1,243 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// iterator produces the keys and values a 'for' loop iterates over one at a time, so nothing has to be copied or
+// allocated up front; ok is false once there are no more values
+type iterator interface {
+	next() (key any, value any, ok bool, err error)
+}
+
+// methodFinder returns a method of a custom type instance bound to that instance; the interpreter and the VM each
+// have their own instances and methods
+type methodFinder func(instance any, name string) (callable, bool)
+
+// newIterator returns an iterator over v for a 'for' loop.
+//
+// Arrays are iterated by index and see changes made in the loop: appended elements are visited too, and the loop
+// ends early when the array shrinks. Maps and sets are iterated over their keys as they were when the loop started
+// (in sorted order); keys that are removed before they are reached are skipped, and keys that are added are not
+// visited. Custom types are iterable when they have an iterator() method returning something iterable, or when they
+// have hasNext() and nextValue() methods.
+func newIterator(v any, findMethod methodFinder) (iterator, error) {
+	switch value := v.(type) {
+	case *[]any:
+		return &arrayIterator{array: value}, nil
+	case *Tuple:
+		return &arrayIterator{array: &value.values}, nil
+	case string:
+		return &stringIterator{s: value}, nil
+	case *ToiMap:
+		return &mapIterator{m: value, keys: value.sortedKeys()}, nil
+	case *ToiSet:
+		return &mapIterator{m: value.elements, keys: value.elements.sortedKeys(), set: true}, nil
+	case *ToiRange:
+		return &rangeIterator{r: value, current: value.from}, nil
+	case *ToiLines:
+		return &linesIterator{text: value.text}, nil
+	case *ToiInstance, *VmInstance:
+		if iteratorMethod, found := findMethod(v, "iterator"); found {
+			inner, err := iteratorMethod.call(nil)
+			if err != nil {
+				return nil, err
+			}
+			if inner != v {
+				return newIterator(inner, findMethod)
+			}
+			// An iterator() that returns the instance itself means the instance is its own iterator
+		}
+		hasNext, hasNextFound := findMethod(v, "hasNext")
+		nextValue, nextValueFound := findMethod(v, "nextValue")
+		if !hasNextFound || !nextValueFound {
+			return nil, fmt.Errorf("cannot iterate over type '%s' without an 'iterator' method or 'hasNext' and 'nextValue' methods", typeName(v))
+		}
+		return &methodIterator{hasNext: hasNext, nextValue: nextValue}, nil
+	}
+	return nil, fmt.Errorf("cannot iterate over '%v'", formatValue(v))
+}
+
+type arrayIterator struct {
+	array *[]any
+	index int
+}
+
+func (it *arrayIterator) next() (any, any, bool, error) {
+	if it.index >= len(*it.array) {
+		return nil, nil, false, nil
+	}
+	index := it.index
+	it.index++
+	return index, (*it.array)[index], true, nil
+}
+
+// stringIterator iterates over the characters (runes) of a string; the key is the index of the character, the same
+// index that [s]i uses
+type stringIterator struct {
+	s      string
+	offset int
+	index  int
+}
+
+func (it *stringIterator) next() (any, any, bool, error) {
+	if it.offset >= len(it.s) {
+		return nil, nil, false, nil
+	}
+	r, size := utf8.DecodeRuneInString(it.s[it.offset:])
+	index := it.index
+	it.offset += size
+	it.index++
+	return index, string(r), true, nil
+}
+
+type mapIterator struct {
+	m     *ToiMap
+	keys  []any
+	index int
+	set   bool
+}
+
+func (it *mapIterator) next() (any, any, bool, error) {
+	for it.index < len(it.keys) {
+		key := it.keys[it.index]
+		it.index++
+		value, found, err := it.m.get(key)
+		if err != nil {
+			return nil, nil, false, err
+		} else if !found {
+			continue // removed during the loop
+		}
+		if it.set {
+			// Like get(set, v), the value of a set element is the element itself
+			value = key
+		}
+		return key, value, true, nil
+	}
+	return nil, nil, false, nil
+}
+
+type rangeIterator struct {
+	r       *ToiRange
+	current any
+	index   int
+}
+
+func (it *rangeIterator) next() (any, any, bool, error) {
+	continues, err := rangeContinues(it.current, it.r.to, it.r.step)
+	if err != nil || !continues {
+		return nil, nil, false, err
+	}
+	value := it.current
+	if it.current, err = arithmetic(it.current, it.r.step, "+"); err != nil {
+		return nil, nil, false, err
+	}
+	index := it.index
+	it.index++
+	return index, value, true, nil
+}
+
+type linesIterator struct {
+	text   string
+	offset int
+	index  int
+	done   bool
+}
+
+func (it *linesIterator) next() (any, any, bool, error) {
+	if it.done {
+		return nil, nil, false, nil
+	}
+	var line string
+	if end := strings.IndexByte(it.text[it.offset:], '\n'); end < 0 {
+		line = it.text[it.offset:]
+		it.done = true
+	} else {
+		line = it.text[it.offset : it.offset+end]
+		it.offset += end + 1
+	}
+	index := it.index
+	it.index++
+	return index, line, true, nil
+}
+
+// methodIterator iterates over a custom type with hasNext() and nextValue() methods; the key is the number of values
+// produced before
+type methodIterator struct {
+	hasNext   callable
+	nextValue callable
+	index     int
+}
+
+func (it *methodIterator) next() (any, any, bool, error) {
+	hasNext, err := it.hasNext.call(nil)
+	if err != nil || !isWeirdlyTrue(hasNext) {
+		return nil, nil, false, err
+	}
+	value, err := it.nextValue.call(nil)
+	if err != nil {
+		return nil, nil, false, err
+	}
+	index := it.index
+	it.index++
+	return index, value, true, nil
+}
+
+// ToiRange is a lazy sequence of ints from 'from' up to (but not including) 'to', with 'step' added each time
+type ToiRange struct {
+	from any
+	to   any
+	step any
+}
+
+func (r *ToiRange) print(out *bytes.Buffer) {
+	out.WriteString(fmt.Sprintf("range(%v, %v, %v)", r.from, r.to, r.step))
+}
+
+func builtinRange(env Env, e []Expression) (any, error) {
+	arguments, err := toArguments(env, e)
+	if err != nil {
+		return nil, err
+	}
+	return builtinRangeVm(arguments)
+}
+
+func builtinRangeVm(arguments []any) (any, error) {
+	// range(from, to) or range(from, to, step)
+	if len(arguments) != 2 && len(arguments) != 3 {
+		return nil, fmt.Errorf("range() needs 2 or 3 arguments but got %d", len(arguments))
+	}
+	numbers, err := getNumbersVm(arguments)
+	if err != nil {
+		return nil, err
+	}
+	r := &ToiRange{from: numbers[0], to: numbers[1], step: 1}
+	if len(numbers) == 3 {
+		if cmpNumbers(numbers[2], 0) == 0 {
+			return nil, fmt.Errorf("range() step cannot be 0")
+		}
+		r.step = numbers[2]
+	}
+	return r, nil
+}
+
+// ToiLines is a lazy sequence of the lines of a text
+type ToiLines struct {
+	text string
+}
+
+func (l *ToiLines) print(out *bytes.Buffer) {
+	out.WriteString("<lines>")
+}
+
+func builtinStdinLines(env Env, e []Expression) (any, error) {
+	return builtinStdinLinesVm(nil)
+}
+
+func builtinStdinLinesVm(arguments []any) (any, error) {
+	// stdinLines(); the same lines as inputLines(), but without reading them all into an array
+	return &ToiLines{text: strings.TrimSpace(toiStdin)}, nil
+}
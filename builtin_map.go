@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"fmt"
+	"math/big"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// ToiMap maps keys to values. Keys can be ints, strings, tuples, and custom type instances; they are stored by their
+// hash key, so keys with the same contents are the same key. Instances are hashed when they are used as a key, so
+// changing an instance afterwards does not change the key it is stored under.
+type ToiMap struct {
+	entries map[any]mapEntry
+}
+
+type mapEntry struct {
+	key   any
+	value any
+}
+
+// compositeKey is the hash key of tuples and instances; it's a separate type so it never equals a string key
+type compositeKey string
+
+func newToiMap() *ToiMap {
+	return &ToiMap{entries: make(map[any]mapEntry)}
+}
+
+func (m *ToiMap) get(key any) (any, bool, error) {
+	hash, err := hashKey(key)
+	if err != nil {
+		return nil, false, err
+	}
+	entry, found := m.entries[hash]
+	return entry.value, found, nil
+}
+
+func (m *ToiMap) set(key any, value any) error {
+	hash, err := hashKey(key)
+	if err != nil {
+		return err
+	}
+	m.entries[hash] = mapEntry{key: key, value: value}
+	return nil
+}
+
+func (m *ToiMap) remove(key any) error {
+	hash, err := hashKey(key)
+	if err != nil {
+		return err
+	}
+	delete(m.entries, hash)
+	return nil
+}
+
+func (m *ToiMap) len() int {
+	return len(m.entries)
+}
+
+// sortedKeys returns the keys ordered by type (ints, strings, tuples, then instances), and then by value; sorting
+// only to get consistent results between invocations
+func (m *ToiMap) sortedKeys() []any {
+	keys := make([]any, 0, len(m.entries))
+	for _, entry := range m.entries {
+		keys = append(keys, entry.key)
+	}
+	slices.SortFunc(keys, compareKeys)
+	return keys
+}
+
+func (m *ToiMap) print(out *bytes.Buffer) {
+	out.WriteRune('{')
+	for i, key := range m.sortedKeys() {
+		if i != 0 {
+			out.WriteString(", ")
+		}
+		writeValue(key, out)
+		out.WriteString(": ")
+		value, _, _ := m.get(key)
+		writeValue(value, out)
+	}
+	out.WriteRune('}')
+}
+
+func compareKeys(left, right any) int {
+	if c := cmp.Compare(keyRank(left), keyRank(right)); c != 0 {
+		return c
+	}
+	if c, err := compare(left, right); err == nil {
+		return c
+	}
+	// E.g. instances of different types, or tuples with elements of different types
+	return cmp.Compare(formatHashKey(left), formatHashKey(right))
+}
+
+func keyRank(key any) int {
+	switch key.(type) {
+	case int, *big.Int:
+		return 0
+	case string:
+		return 1
+	case *Tuple:
+		return 2
+	}
+	return 3
+}
+
+func hashKey(key any) (any, error) {
+	switch k := key.(type) {
+	case int, string:
+		return k, nil
+	case *big.Int, *Tuple, *ToiInstance, *VmInstance:
+		b := &strings.Builder{}
+		if err := writeHashKey(key, b); err != nil {
+			return nil, err
+		}
+		return compositeKey(b.String()), nil
+	}
+	return nil, invalidKeyError(key)
+}
+
+func formatHashKey(key any) string {
+	b := &strings.Builder{}
+	_ = writeHashKey(key, b)
+	return b.String()
+}
+
+func writeHashKey(key any, b *strings.Builder) error {
+	switch k := key.(type) {
+	case nil:
+		b.WriteString("nil")
+	case int:
+		b.WriteString(strconv.Itoa(k))
+	case *big.Int:
+		b.WriteString(k.String())
+	case string:
+		b.WriteString(strconv.Quote(k))
+	case *Tuple:
+		return writeHashKeys("(", k.values, ")", b)
+	case *ToiInstance:
+		return writeHashKeys(k.toiType.Identifier.Lexeme+"{", k.fieldValues, "}", b)
+	case *VmInstance:
+		return writeHashKeys(k.vmType.Name+"{", k.values, "}", b)
+	default:
+		return invalidKeyError(key)
+	}
+	return nil
+}
+
+func writeHashKeys(open string, keys []any, close string, b *strings.Builder) error {
+	b.WriteString(open)
+	for i, key := range keys {
+		if i != 0 {
+			b.WriteRune(',')
+		}
+		if err := writeHashKey(key, b); err != nil {
+			return err
+		}
+	}
+	b.WriteString(close)
+	return nil
+}
+
+func invalidKeyError(key any) error {
+	return fmt.Errorf("cannot use '%v' as a map key; only ints, strings, tuples, and custom types (of those) can be keys", formatValue(key))
+}
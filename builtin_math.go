@@ -0,0 +1,292 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"strings"
+)
+
+func getNumberVm(arguments []any, index int) (any, error) {
+	v := arguments[index]
+	if !isNumber(v) {
+		return nil, fmt.Errorf("%s argument needs to be an int, but was '%v'", ordinal(index), formatValue(v))
+	}
+	return v, nil
+}
+
+func getNumbersVm(arguments []any) ([]any, error) {
+	numbers := make([]any, len(arguments))
+	for i := range arguments {
+		n, err := getNumberVm(arguments, i)
+		if err != nil {
+			return nil, err
+		}
+		numbers[i] = n
+	}
+	return numbers, nil
+}
+
+// variadicOrArray returns the elements of the array if the only argument is an array, e.g. for max(a, b, c) and
+// max(array)
+func variadicOrArray(arguments []any) []any {
+	if len(arguments) == 1 {
+		if array, ok := arguments[0].(*[]any); ok {
+			return *array
+		}
+	}
+	return arguments
+}
+
+func builtinAbs(env Env, e []Expression) (any, error) {
+	arguments, err := toArguments(env, e)
+	if err != nil {
+		return nil, err
+	}
+	return builtinAbsVm(arguments)
+}
+
+func builtinAbsVm(arguments []any) (any, error) {
+	// abs(n)
+	n, err := getNumberVm(arguments, 0)
+	if err != nil {
+		return nil, err
+	}
+	if i, ok := n.(int); ok && i != math.MinInt {
+		return max(i, -i), nil
+	}
+	return normalizeBig(new(big.Int).Abs(toBig(n))), nil
+}
+
+func builtinSign(env Env, e []Expression) (any, error) {
+	arguments, err := toArguments(env, e)
+	if err != nil {
+		return nil, err
+	}
+	return builtinSignVm(arguments)
+}
+
+func builtinSignVm(arguments []any) (any, error) {
+	// sign(n)
+	n, err := getNumberVm(arguments, 0)
+	if err != nil {
+		return nil, err
+	}
+	return cmpNumbers(n, 0), nil
+}
+
+func builtinMin(env Env, e []Expression) (any, error) {
+	arguments, err := toArguments(env, e)
+	if err != nil {
+		return nil, err
+	}
+	return builtinMinVm(arguments)
+}
+
+func builtinMinVm(arguments []any) (any, error) {
+	// min(a, b, ...) or min(array)
+	return extreme("min", variadicOrArray(arguments), func(c int) bool { return c < 0 })
+}
+
+func builtinMax(env Env, e []Expression) (any, error) {
+	arguments, err := toArguments(env, e)
+	if err != nil {
+		return nil, err
+	}
+	return builtinMaxVm(arguments)
+}
+
+func builtinMaxVm(arguments []any) (any, error) {
+	// max(a, b, ...) or max(array)
+	return extreme("max", variadicOrArray(arguments), func(c int) bool { return c > 0 })
+}
+
+// extreme returns the first value for which better returns true when compared to all other values; values can be
+// anything that can be compared (so not just ints)
+func extreme(name string, values []any, better func(c int) bool) (any, error) {
+	if len(values) == 0 {
+		return nil, fmt.Errorf("%s() needs at least one value", name)
+	}
+	result := values[0]
+	for _, v := range values[1:] {
+		c, err := compare(v, result)
+		if err != nil {
+			return nil, err
+		}
+		if better(c) {
+			result = v
+		}
+	}
+	return result, nil
+}
+
+func builtinSum(env Env, e []Expression) (any, error) {
+	arguments, err := toArguments(env, e)
+	if err != nil {
+		return nil, err
+	}
+	return builtinSumVm(arguments)
+}
+
+func builtinSumVm(arguments []any) (any, error) {
+	// sum(a, b, ...) or sum(array)
+	numbers, err := getNumbersVm(variadicOrArray(arguments))
+	if err != nil {
+		return nil, err
+	}
+	var total any = 0
+	for _, n := range numbers {
+		if total, err = arithmetic(total, n, "+"); err != nil {
+			return nil, err
+		}
+	}
+	return total, nil
+}
+
+func builtinPow(env Env, e []Expression) (any, error) {
+	arguments, err := toArguments(env, e)
+	if err != nil {
+		return nil, err
+	}
+	return builtinPowVm(arguments)
+}
+
+func builtinPowVm(arguments []any) (any, error) {
+	// pow(base, exponent)
+	numbers, err := getNumbersVm(arguments)
+	if err != nil {
+		return nil, err
+	}
+	base, exponent := toBig(numbers[0]), toBig(numbers[1])
+	if exponent.Sign() < 0 {
+		return nil, fmt.Errorf("exponent cannot be negative, but was '%v'", formatValue(exponent))
+	}
+	// The result has about exponent * base.BitLen() bits; only 0, 1 and -1 stay small with any exponent
+	if base.CmpAbs(big.NewInt(1)) > 0 {
+		bits := new(big.Int).Mul(exponent, big.NewInt(int64(base.BitLen())))
+		if bits.Cmp(big.NewInt(maxResultBits)) > 0 {
+			return nil, fmt.Errorf("result of pow(%v, %v) is too large; it can have at most %d bits", formatValue(numbers[0]), formatValue(numbers[1]), maxResultBits)
+		}
+	}
+	return normalizeBig(new(big.Int).Exp(base, exponent, nil)), nil
+}
+
+func builtinModPow(env Env, e []Expression) (any, error) {
+	arguments, err := toArguments(env, e)
+	if err != nil {
+		return nil, err
+	}
+	return builtinModPowVm(arguments)
+}
+
+func builtinModPowVm(arguments []any) (any, error) {
+	// modPow(base, exponent, modulus)
+	numbers, err := getNumbersVm(arguments)
+	if err != nil {
+		return nil, err
+	}
+	base, exponent, modulus := toBig(numbers[0]), toBig(numbers[1]), toBig(numbers[2])
+	if exponent.Sign() < 0 {
+		return nil, fmt.Errorf("exponent cannot be negative, but was '%v'", formatValue(exponent))
+	}
+	if modulus.Sign() == 0 {
+		return nil, fmt.Errorf("division by zero")
+	}
+	modulus = new(big.Int).Abs(modulus)
+	result := new(big.Int).Exp(base, exponent, modulus)
+	// Exp leaves the result negative for a negative base; the result is always in [0, modulus)
+	return normalizeBig(result.Mod(result, modulus)), nil
+}
+
+func builtinSqrt(env Env, e []Expression) (any, error) {
+	arguments, err := toArguments(env, e)
+	if err != nil {
+		return nil, err
+	}
+	return builtinSqrtVm(arguments)
+}
+
+func builtinSqrtVm(arguments []any) (any, error) {
+	// sqrt(n); Toi only has ints, so this is the square root rounded down
+	n, err := getNumberVm(arguments, 0)
+	if err != nil {
+		return nil, err
+	}
+	b := toBig(n)
+	if b.Sign() < 0 {
+		return nil, fmt.Errorf("cannot take the square root of negative number '%v'", formatValue(b))
+	}
+	return normalizeBig(new(big.Int).Sqrt(b)), nil
+}
+
+func builtinGcd(env Env, e []Expression) (any, error) {
+	arguments, err := toArguments(env, e)
+	if err != nil {
+		return nil, err
+	}
+	return builtinGcdVm(arguments)
+}
+
+func builtinGcdVm(arguments []any) (any, error) {
+	// gcd(a, b)
+	numbers, err := getNumbersVm(arguments)
+	if err != nil {
+		return nil, err
+	}
+	return normalizeBig(gcd(toBig(numbers[0]), toBig(numbers[1]))), nil
+}
+
+func builtinLcm(env Env, e []Expression) (any, error) {
+	arguments, err := toArguments(env, e)
+	if err != nil {
+		return nil, err
+	}
+	return builtinLcmVm(arguments)
+}
+
+func builtinLcmVm(arguments []any) (any, error) {
+	// lcm(a, b)
+	numbers, err := getNumbersVm(arguments)
+	if err != nil {
+		return nil, err
+	}
+	a, b := toBig(numbers[0]), toBig(numbers[1])
+	if a.Sign() == 0 || b.Sign() == 0 {
+		return 0, nil
+	}
+	result := new(big.Int).Mul(a, b)
+	result.Abs(result).Quo(result, gcd(a, b))
+	return normalizeBig(result), nil
+}
+
+// gcd always returns a non-negative number; gcd(0, 0) is 0
+func gcd(a, b *big.Int) *big.Int {
+	return new(big.Int).GCD(nil, nil, a, b)
+}
+
+func builtinToBinary(env Env, e []Expression) (any, error) {
+	arguments, err := toArguments(env, e)
+	if err != nil {
+		return nil, err
+	}
+	return builtinToBinaryVm(arguments)
+}
+
+func builtinToBinaryVm(arguments []any) (any, error) {
+	// toBinary(n, width); the result is padded with zeroes to at least width digits
+	n, err := getNumberVm(arguments, 0)
+	if err != nil {
+		return nil, err
+	}
+	width, ok := arguments[1].(int)
+	if !ok || width < 0 {
+		return nil, fmt.Errorf("second argument needs to be a non-negative int, but was '%v'", formatValue(arguments[1]))
+	}
+
+	b := toBig(n)
+	if b.Sign() < 0 {
+		return nil, fmt.Errorf("cannot convert negative number '%v' to binary", formatValue(b))
+	}
+	digits := b.Text(2)
+	return strings.Repeat("0", max(0, width-len(digits))) + digits, nil
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// TODO: global state is bad; reset for every script run in runScript
+var regexCache map[string]*regexp.Regexp
+
+func compileRegex(arguments []any) (*regexp.Regexp, error) {
+	pattern, err := stringArgumentVm(arguments, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	if regex, found := regexCache[pattern]; found {
+		return regex, nil
+	}
+
+	regex, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid regular expression '%s': %v", pattern, err)
+	}
+	regexCache[pattern] = regex
+	return regex, nil
+}
+
+func regexAndStringVm(arguments []any) (*regexp.Regexp, string, error) {
+	regex, err := compileRegex(arguments)
+	if err != nil {
+		return nil, "", err
+	}
+	s, err := stringArgumentVm(arguments, 1)
+	if err != nil {
+		return nil, "", err
+	}
+	return regex, s, nil
+}
+
+func builtinTest(env Env, e []Expression) (any, error) {
+	arguments, err := toArguments(env, e)
+	if err != nil {
+		return nil, err
+	}
+	return builtinTestVm(arguments)
+}
+
+func builtinTestVm(arguments []any) (any, error) {
+	// test("[0-9]+", s)
+	regex, s, err := regexAndStringVm(arguments)
+	if err != nil {
+		return nil, err
+	}
+	return boolToInt(regex.MatchString(s)), nil
+}
+
+func builtinMatch(env Env, e []Expression) (any, error) {
+	arguments, err := toArguments(env, e)
+	if err != nil {
+		return nil, err
+	}
+	return builtinMatchVm(arguments)
+}
+
+func builtinMatchVm(arguments []any) (any, error) {
+	// match("([0-9]+)-([0-9]+)", s)
+	regex, s, err := regexAndStringVm(arguments)
+	if err != nil {
+		return nil, err
+	}
+	// The full match followed by the groups, or an empty array if there is no match
+	return toToiArray(regex.FindStringSubmatch(s)), nil
+}
+
+func builtinMatchAll(env Env, e []Expression) (any, error) {
+	arguments, err := toArguments(env, e)
+	if err != nil {
+		return nil, err
+	}
+	return builtinMatchAllVm(arguments)
+}
+
+func builtinMatchAllVm(arguments []any) (any, error) {
+	// matchAll("[0-9]+", s)
+	regex, s, err := regexAndStringVm(arguments)
+	if err != nil {
+		return nil, err
+	}
+	return toToiArray(regex.FindAllString(s, -1)), nil
+}
+
+func builtinFindGroups(env Env, e []Expression) (any, error) {
+	arguments, err := toArguments(env, e)
+	if err != nil {
+		return nil, err
+	}
+	return builtinFindGroupsVm(arguments)
+}
+
+func builtinFindGroupsVm(arguments []any) (any, error) {
+	// findGroups("([0-9]+)-([0-9]+)", s)
+	regex, s, err := regexAndStringVm(arguments)
+	if err != nil {
+		return nil, err
+	}
+	submatches := regex.FindStringSubmatch(s)
+	if submatches == nil {
+		return toToiArray(submatches), nil
+	}
+	return toToiArray(submatches[1:]), nil
+}
+
+func builtinReplaceRegex(env Env, e []Expression) (any, error) {
+	arguments, err := toArguments(env, e)
+	if err != nil {
+		return nil, err
+	}
+	return builtinReplaceRegexVm(arguments)
+}
+
+func builtinReplaceRegexVm(arguments []any) (any, error) {
+	// replaceRegex("([a-z]+)", s, "<$1>")
+	regex, s, err := regexAndStringVm(arguments)
+	if err != nil {
+		return nil, err
+	}
+	replacement, err := stringArgumentVm(arguments, 2)
+	if err != nil {
+		return nil, err
+	}
+	return regex.ReplaceAllString(s, replacement), nil
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// ToiSet is a set of values, which can be anything that can be a map key
+type ToiSet struct {
+	elements *ToiMap
+}
+
+func newToiSet() *ToiSet {
+	return &ToiSet{elements: newToiMap()}
+}
+
+func (s *ToiSet) add(v any) (bool, error) {
+	hash, err := hashKey(v)
+	if err != nil {
+		return false, err
+	}
+	if _, found := s.elements.entries[hash]; found {
+		return false, nil
+	}
+	s.elements.entries[hash] = mapEntry{key: v}
+	return true, nil
+}
+
+func (s *ToiSet) has(v any) (bool, error) {
+	_, found, err := s.elements.get(v)
+	return found, err
+}
+
+func (s *ToiSet) print(out *bytes.Buffer) {
+	out.WriteRune('{')
+	for i, element := range s.elements.sortedKeys() {
+		if i != 0 {
+			out.WriteString(", ")
+		}
+		writeValue(element, out)
+	}
+	out.WriteRune('}')
+}
+
+func getSetVm(arguments []any, index int) (*ToiSet, error) {
+	v := arguments[index]
+	s, ok := v.(*ToiSet)
+	if !ok {
+		return nil, fmt.Errorf("%s argument needs to be a set, but was '%v'", ordinal(index), formatValue(v))
+	}
+	return s, nil
+}
+
+func builtinNewSet(env Env, e []Expression) (any, error) {
+	return builtinNewSetVm(nil)
+}
+
+func builtinNewSetVm(arguments []any) (any, error) {
+	// newSet()
+	return newToiSet(), nil
+}
+
+func builtinToSet(env Env, e []Expression) (any, error) {
+	arguments, err := toArguments(env, e)
+	if err != nil {
+		return nil, err
+	}
+	return builtinToSetVm(arguments)
+}
+
+func builtinToSetVm(arguments []any) (any, error) {
+	// toSet(array)
+	array, ok := arguments[0].(*[]any)
+	if !ok {
+		return nil, fmt.Errorf("argument to toSet() needs to be an array, but was '%v'", formatValue(arguments[0]))
+	}
+	s := newToiSet()
+	for _, v := range *array {
+		if _, err := s.add(v); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+func builtinAdd(env Env, e []Expression) (any, error) {
+	arguments, err := toArguments(env, e)
+	if err != nil {
+		return nil, err
+	}
+	return builtinAddVm(arguments)
+}
+
+func builtinAddVm(arguments []any) (any, error) {
+	// add(s, v)
+	s, err := getSetVm(arguments, 0)
+	if err != nil {
+		return nil, err
+	}
+	added, err := s.add(arguments[1])
+	return boolToInt(added), err
+}
+
+func builtinHas(env Env, e []Expression) (any, error) {
+	arguments, err := toArguments(env, e)
+	if err != nil {
+		return nil, err
+	}
+	return builtinHasVm(arguments)
+}
+
+func builtinHasVm(arguments []any) (any, error) {
+	// has(s, v)
+	s, err := getSetVm(arguments, 0)
+	if err != nil {
+		return nil, err
+	}
+	found, err := s.has(arguments[1])
+	return boolToInt(found), err
+}
+
+func builtinRemove(env Env, e []Expression) (any, error) {
+	arguments, err := toArguments(env, e)
+	if err != nil {
+		return nil, err
+	}
+	return builtinRemoveVm(arguments)
+}
+
+func builtinRemoveVm(arguments []any) (any, error) {
+	// remove(s, v)
+	s, err := getSetVm(arguments, 0)
+	if err != nil {
+		return nil, err
+	}
+	found, err := s.has(arguments[1])
+	if err != nil || !found {
+		return 0, err
+	}
+	return 1, s.elements.remove(arguments[1])
+}
+
+// setOperationVm creates a new set with the elements of the first set for which keep returns true, followed by the
+// elements of the second set if addOther is true
+func setOperationVm(arguments []any, keep func(inOther bool) bool, addOther bool) (any, error) {
+	left, err := getSetVm(arguments, 0)
+	if err != nil {
+		return nil, err
+	}
+	right, err := getSetVm(arguments, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	result := newToiSet()
+	for hash, entry := range left.elements.entries {
+		if _, inOther := right.elements.entries[hash]; keep(inOther) {
+			result.elements.entries[hash] = entry
+		}
+	}
+	if addOther {
+		for hash, entry := range right.elements.entries {
+			result.elements.entries[hash] = entry
+		}
+	}
+	return result, nil
+}
+
+func builtinUnion(env Env, e []Expression) (any, error) {
+	arguments, err := toArguments(env, e)
+	if err != nil {
+		return nil, err
+	}
+	return builtinUnionVm(arguments)
+}
+
+func builtinUnionVm(arguments []any) (any, error) {
+	// union(a, b)
+	return setOperationVm(arguments, func(bool) bool { return true }, true)
+}
+
+func builtinIntersection(env Env, e []Expression) (any, error) {
+	arguments, err := toArguments(env, e)
+	if err != nil {
+		return nil, err
+	}
+	return builtinIntersectionVm(arguments)
+}
+
+func builtinIntersectionVm(arguments []any) (any, error) {
+	// intersection(a, b)
+	return setOperationVm(arguments, func(inOther bool) bool { return inOther }, false)
+}
+
+func builtinDifference(env Env, e []Expression) (any, error) {
+	arguments, err := toArguments(env, e)
+	if err != nil {
+		return nil, err
+	}
+	return builtinDifferenceVm(arguments)
+}
+
+func builtinDifferenceVm(arguments []any) (any, error) {
+	// difference(a, b)
+	return setOperationVm(arguments, func(inOther bool) bool { return !inOther }, false)
+}
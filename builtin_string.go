@@ -0,0 +1,354 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// maxStringLength limits the length in characters of the strings made by repeat() and padLeft(), which would otherwise
+// run out of memory for large counts and widths
+const maxStringLength = 1 << 24
+
+func stringArgumentVm(arguments []any, index int) (string, error) {
+	v := arguments[index]
+	s, ok := v.(string)
+	if !ok {
+		return "", fmt.Errorf("%s argument needs to be a string, but was '%v'", ordinal(index), formatValue(v))
+	}
+	return s, nil
+}
+
+func intArgumentVm(arguments []any, index int) (int, error) {
+	v := arguments[index]
+	i, ok := v.(int)
+	if !ok {
+		return 0, fmt.Errorf("%s argument needs to be an int, but was '%v'", ordinal(index), formatValue(v))
+	}
+	return i, nil
+}
+
+func ordinal(index int) string {
+	switch index {
+	case 0:
+		return "first"
+	case 1:
+		return "second"
+	case 2:
+		return "third"
+	}
+	return fmt.Sprintf("%dth", index+1)
+}
+
+func builtinTrim(env Env, e []Expression) (any, error) {
+	arguments, err := toArguments(env, e)
+	if err != nil {
+		return nil, err
+	}
+	return builtinTrimVm(arguments)
+}
+
+func builtinTrimVm(arguments []any) (any, error) {
+	// trim(s)
+	s, err := stringArgumentVm(arguments, 0)
+	if err != nil {
+		return nil, err
+	}
+	return strings.TrimSpace(s), nil
+}
+
+func builtinStartsWith(env Env, e []Expression) (any, error) {
+	arguments, err := toArguments(env, e)
+	if err != nil {
+		return nil, err
+	}
+	return builtinStartsWithVm(arguments)
+}
+
+func builtinStartsWithVm(arguments []any) (any, error) {
+	// startsWith(s, "prefix")
+	return stringPredicateVm(arguments, strings.HasPrefix)
+}
+
+func builtinEndsWith(env Env, e []Expression) (any, error) {
+	arguments, err := toArguments(env, e)
+	if err != nil {
+		return nil, err
+	}
+	return builtinEndsWithVm(arguments)
+}
+
+func builtinEndsWithVm(arguments []any) (any, error) {
+	// endsWith(s, "suffix")
+	return stringPredicateVm(arguments, strings.HasSuffix)
+}
+
+func builtinContains(env Env, e []Expression) (any, error) {
+	arguments, err := toArguments(env, e)
+	if err != nil {
+		return nil, err
+	}
+	return builtinContainsVm(arguments)
+}
+
+func builtinContainsVm(arguments []any) (any, error) {
+	// contains(s, "substring")
+	return stringPredicateVm(arguments, strings.Contains)
+}
+
+func stringPredicateVm(arguments []any, predicate func(string, string) bool) (any, error) {
+	s, err := stringArgumentVm(arguments, 0)
+	if err != nil {
+		return nil, err
+	}
+	other, err := stringArgumentVm(arguments, 1)
+	if err != nil {
+		return nil, err
+	}
+	return boolToInt(predicate(s, other)), nil
+}
+
+func builtinIndexOf(env Env, e []Expression) (any, error) {
+	arguments, err := toArguments(env, e)
+	if err != nil {
+		return nil, err
+	}
+	return builtinIndexOfVm(arguments)
+}
+
+func builtinIndexOfVm(arguments []any) (any, error) {
+	// indexOf(s, "substring")
+	s, err := stringArgumentVm(arguments, 0)
+	if err != nil {
+		return nil, err
+	}
+	substring, err := stringArgumentVm(arguments, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	byteIndex := strings.Index(s, substring)
+	if byteIndex == -1 {
+		return -1, nil
+	}
+	// Toi strings are indexed by character, not by byte
+	return utf8.RuneCountInString(s[:byteIndex]), nil
+}
+
+func builtinSubstring(env Env, e []Expression) (any, error) {
+	arguments, err := toArguments(env, e)
+	if err != nil {
+		return nil, err
+	}
+	return builtinSubstringVm(arguments)
+}
+
+func builtinSubstringVm(arguments []any) (any, error) {
+	// substring(s, start, end)
+	s, err := stringArgumentVm(arguments, 0)
+	if err != nil {
+		return nil, err
+	}
+	start, err := intArgumentVm(arguments, 1)
+	if err != nil {
+		return nil, err
+	}
+	end, err := intArgumentVm(arguments, 2)
+	if err != nil {
+		return nil, err
+	}
+
+	runes := []rune(s)
+	if start < 0 || end > len(runes) || start > end {
+		return nil, fmt.Errorf("substring range %d..%d out of bounds (length %d)", start, end, len(runes))
+	}
+	return string(runes[start:end]), nil
+}
+
+func builtinReplace(env Env, e []Expression) (any, error) {
+	arguments, err := toArguments(env, e)
+	if err != nil {
+		return nil, err
+	}
+	return builtinReplaceVm(arguments)
+}
+
+func builtinReplaceVm(arguments []any) (any, error) {
+	// replace(s, "old", "new")
+	s, err := stringArgumentVm(arguments, 0)
+	if err != nil {
+		return nil, err
+	}
+	old, err := stringArgumentVm(arguments, 1)
+	if err != nil {
+		return nil, err
+	}
+	replacement, err := stringArgumentVm(arguments, 2)
+	if err != nil {
+		return nil, err
+	}
+	return strings.ReplaceAll(s, old, replacement), nil
+}
+
+func builtinUpper(env Env, e []Expression) (any, error) {
+	arguments, err := toArguments(env, e)
+	if err != nil {
+		return nil, err
+	}
+	return builtinUpperVm(arguments)
+}
+
+func builtinUpperVm(arguments []any) (any, error) {
+	// upper(s)
+	s, err := stringArgumentVm(arguments, 0)
+	if err != nil {
+		return nil, err
+	}
+	return strings.ToUpper(s), nil
+}
+
+func builtinLower(env Env, e []Expression) (any, error) {
+	arguments, err := toArguments(env, e)
+	if err != nil {
+		return nil, err
+	}
+	return builtinLowerVm(arguments)
+}
+
+func builtinLowerVm(arguments []any) (any, error) {
+	// lower(s)
+	s, err := stringArgumentVm(arguments, 0)
+	if err != nil {
+		return nil, err
+	}
+	return strings.ToLower(s), nil
+}
+
+func builtinRepeat(env Env, e []Expression) (any, error) {
+	arguments, err := toArguments(env, e)
+	if err != nil {
+		return nil, err
+	}
+	return builtinRepeatVm(arguments)
+}
+
+func builtinRepeatVm(arguments []any) (any, error) {
+	// repeat(s, 3)
+	s, err := stringArgumentVm(arguments, 0)
+	if err != nil {
+		return nil, err
+	}
+	count, err := intArgumentVm(arguments, 1)
+	if err != nil {
+		return nil, err
+	}
+	if count < 0 {
+		return nil, fmt.Errorf("repeat count may not be negative, but was %d", count)
+	} else if length := utf8.RuneCountInString(s); length != 0 && count > maxStringLength/length {
+		return nil, fmt.Errorf("repeat count %d is too large; the result can be at most %d characters long", count, maxStringLength)
+	}
+	return strings.Repeat(s, count), nil
+}
+
+func builtinJoin(env Env, e []Expression) (any, error) {
+	arguments, err := toArguments(env, e)
+	if err != nil {
+		return nil, err
+	}
+	return builtinJoinVm(arguments)
+}
+
+func builtinJoinVm(arguments []any) (any, error) {
+	// join(array, ", ")
+	v := arguments[0]
+	array, ok := v.(*[]any)
+	if !ok {
+		return nil, fmt.Errorf("first argument needs to be an array, but was '%v'", formatValue(v))
+	}
+	separator, err := stringArgumentVm(arguments, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	elements := make([]string, len(*array))
+	for i, element := range *array {
+		s, ok := element.(string)
+		if !ok {
+			return nil, fmt.Errorf("array element %d needs to be a string, but was '%v'", i, formatValue(element))
+		}
+		elements[i] = s
+	}
+	return strings.Join(elements, separator), nil
+}
+
+func builtinPadLeft(env Env, e []Expression) (any, error) {
+	arguments, err := toArguments(env, e)
+	if err != nil {
+		return nil, err
+	}
+	return builtinPadLeftVm(arguments)
+}
+
+func builtinPadLeftVm(arguments []any) (any, error) {
+	// padLeft(s, 5, "0")
+	s, err := stringArgumentVm(arguments, 0)
+	if err != nil {
+		return nil, err
+	}
+	width, err := intArgumentVm(arguments, 1)
+	if err != nil {
+		return nil, err
+	}
+	padding, err := stringArgumentVm(arguments, 2)
+	if err != nil {
+		return nil, err
+	}
+	if utf8.RuneCountInString(padding) != 1 {
+		return nil, fmt.Errorf("third argument needs to be a single character, but was '%v'", formatValue(padding))
+	}
+
+	if width > maxStringLength {
+		return nil, fmt.Errorf("width %d is too large; it can be at most %d", width, maxStringLength)
+	}
+
+	length := utf8.RuneCountInString(s)
+	if length >= width {
+		return s, nil
+	}
+	return strings.Repeat(padding, width-length) + s, nil
+}
+
+func builtinFormat(env Env, e []Expression) (any, error) {
+	arguments, err := toArguments(env, e)
+	if err != nil {
+		return nil, err
+	}
+	return builtinFormatVm(arguments)
+}
+
+func builtinFormatVm(arguments []any) (any, error) {
+	// format("{} is {}", a, b)
+	if len(arguments) == 0 {
+		return nil, fmt.Errorf("format() needs at least a format string")
+	}
+	template, err := stringArgumentVm(arguments, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	values := arguments[1:]
+	parts := strings.Split(template, "{}")
+	if len(parts)-1 != len(values) {
+		return nil, fmt.Errorf("format string has %d placeholders but got %d values", len(parts)-1, len(values))
+	}
+
+	out := &bytes.Buffer{}
+	for i, part := range parts {
+		out.WriteString(part)
+		if i < len(values) {
+			writeValue(values[i], out)
+		}
+	}
+	return out.String(), nil
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"slices"
+)
+
+// Tuple is a fixed-size, read-only group of values, e.g. the result of a function with multiple out-variables
+type Tuple struct {
+	values []any
+}
+
+func (t *Tuple) print(out *bytes.Buffer) {
+	out.WriteRune('(')
+	for i, v := range t.values {
+		if i != 0 {
+			out.WriteString(", ")
+		}
+		writeValue(v, out)
+	}
+	out.WriteRune(')')
+}
+
+// destructure returns the values of a tuple or array, which need to be exactly count values
+func destructure(v any, count int) ([]any, error) {
+	var values []any
+	if tuple, ok := v.(*Tuple); ok {
+		values = tuple.values
+	} else if array, ok := v.(*[]any); ok {
+		values = *array
+	} else {
+		return nil, fmt.Errorf("cannot destructure '%v'; only tuples and arrays can be destructured", formatValue(v))
+	}
+
+	if len(values) != count {
+		return nil, fmt.Errorf("cannot assign %d values to %d variables", len(values), count)
+	}
+	return values, nil
+}
+
+func compareTuple(left, right *Tuple) (int, error) {
+	for i := 0; i < len(left.values) && i < len(right.values); i++ {
+		if c, err := compare(left.values[i], right.values[i]); c != 0 || err != nil {
+			return c, err
+		}
+	}
+	// A tuple that is a prefix of another tuple comes first
+	return len(left.values) - len(right.values), nil
+}
+
+func builtinTuple(env Env, e []Expression) (any, error) {
+	arguments, err := toArguments(env, e)
+	if err != nil {
+		return nil, err
+	}
+	return builtinTupleVm(arguments)
+}
+
+func builtinTupleVm(arguments []any) (any, error) {
+	// tuple(1, "two", 3)
+	return &Tuple{values: slices.Clone(arguments)}, nil
+}
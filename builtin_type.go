@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"math/big"
+)
+
+// typeName returns the name of the type of a value, which is the declared name for custom types
+func typeName(v any) string {
+	switch value := v.(type) {
+	case nil:
+		return "nil"
+	case int, *big.Int:
+		return "int"
+	case string:
+		return "string"
+	case *[]any:
+		return "array"
+	case *ToiMap:
+		return "map"
+	case *ToiSet:
+		return "set"
+	case *ToiHeap:
+		return "heap"
+	case *Tuple:
+		return "tuple"
+	case *ToiRange:
+		return "range"
+	case *ToiLines:
+		return "lines"
+	case *ToiError:
+		return "error"
+	case *ToiInstance:
+		return value.toiType.Identifier.Lexeme
+	case *VmInstance:
+		return value.vmType.Name
+	case callable:
+		return "function"
+	}
+	panic(fmt.Sprintf("unknown type of value '%v'", v))
+}
+
+// builtinTypeNames are the names typeName returns for values that are not instances of custom types
+var builtinTypeNames = map[string]struct{}{
+	"nil": {}, "int": {}, "string": {}, "array": {}, "map": {}, "set": {}, "heap": {}, "tuple": {}, "range": {},
+	"lines": {}, "error": {}, "function": {},
+}
+
+func builtinTypeOf(env Env, e []Expression) (any, error) {
+	arguments, err := toArguments(env, e)
+	if err != nil {
+		return nil, err
+	}
+	return builtinTypeOfVm(arguments)
+}
+
+func builtinTypeOfVm(arguments []any) (any, error) {
+	// typeOf(v)
+	return typeName(arguments[0]), nil
+}
+
+func builtinIsType(env Env, e []Expression) (any, error) {
+	arguments, err := toArguments(env, e)
+	if err != nil {
+		return nil, err
+	}
+	return builtinIsTypeVm(arguments)
+}
+
+func builtinIsTypeVm(arguments []any) (any, error) {
+	// isType(v, "Item")
+	name, err := stringArgumentVm(arguments, 1)
+	if err != nil {
+		return nil, err
+	}
+	return boolToInt(typeName(arguments[0]) == name), nil
+}
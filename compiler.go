@@ -2,6 +2,7 @@ package main
 
 import (
 	"fmt"
+	"strconv"
 )
 
 // TODO: use a bytebuffer instead of slices for efficiency; although slices are nice and easy to patch jumps
@@ -9,17 +10,30 @@ import (
 type LoopState struct {
 	exitLoops      []int
 	nextIterations []int
+	attemptDepth   int // the number of attempt blocks around the loop
+}
+
+// StatementPosition is the position of the statement that the ops from start up to end were compiled from, so the VM
+// can tell where an error happened
+type StatementPosition struct {
+	start   int
+	end     int
+	lineCol LineCol
 }
 
 type Compiler struct {
 	constants []any
 	bytes     []byte
 	variables []string // index = id; value = name
+	positions []StatementPosition
 
 	loopStates    []*LoopState
 	functions     map[string]VmFunction
 	exitFunctions []int
 	declaredTypes map[string]VmType
+	attemptDepth  int // the number of attempt blocks being compiled, which the VM has failure handlers for
+
+	constructorCount int // to give the temporary variables of every constructor call unique names
 }
 
 func (c *Compiler) writeByte(b byte) {
@@ -39,7 +53,15 @@ func (c *Compiler) currentLoopState() *LoopState {
 }
 
 func (c *Compiler) pushLoopState() {
-	c.loopStates = append(c.loopStates, &LoopState{})
+	c.loopStates = append(c.loopStates, &LoopState{attemptDepth: c.attemptDepth})
+}
+
+// writeEndAttempts removes the failure handlers of the attempt blocks that are jumped out of by exit loop, next
+// iteration or exit function
+func (c *Compiler) writeEndAttempts(outerDepth int) {
+	for range c.attemptDepth - outerDepth {
+		c.writeByte(OpEndAttempt)
+	}
 }
 
 func (c *Compiler) popLoopState() {
@@ -57,6 +79,25 @@ func (c *Compiler) addNextIteration(index int) {
 	loopState.nextIterations = append(loopState.nextIterations, index)
 }
 
+// writeJump writes a jump whose amount is filled in later by patchJumps, and adds its index to jumps
+func (c *Compiler) writeJump(op byte, jumps *[]int) {
+	*jumps = append(*jumps, c.len())
+	c.writeBytes(op, InvalidOp, InvalidOp)
+}
+
+// patchJumps makes the forward jumps at the indexes jump to the current end of the ops
+func (c *Compiler) patchJumps(jumps []int) error {
+	for _, index := range jumps {
+		b1, b2, err := encodeJumpAmount(c.len() - index - 3)
+		if err != nil {
+			return err
+		}
+		c.setByte(index+1, b1)
+		c.setByte(index+2, b2)
+	}
+	return nil
+}
+
 func (c *Compiler) len() int {
 	return len(c.bytes)
 }
@@ -64,6 +105,19 @@ func (c *Compiler) len() int {
 // Statements
 
 func (s *BlockStatement) compile(compiler *Compiler) error {
+	for _, stmt := range s.Statements {
+		start := compiler.len()
+		if err := stmt.compile(compiler); err != nil {
+			return err
+		}
+		if lineCol := stmt.lineCol(); lineCol.line != 0 {
+			compiler.positions = append(compiler.positions, StatementPosition{start: start, end: compiler.len(), lineCol: lineCol})
+		}
+	}
+	return nil
+}
+
+func (s *ImportStatement) compile(compiler *Compiler) error {
 	for _, stmt := range s.Statements {
 		if err := stmt.compile(compiler); err != nil {
 			return err
@@ -220,19 +274,209 @@ func (s *WhileStatement) compile(compiler *Compiler) error {
 	return nil
 }
 
+func (s *MatchStatement) compile(compiler *Compiler) error {
+	subjectIndex, err := compiler.registerVariable(s.Subject.Lexeme)
+	if err != nil {
+		return err
+	}
+	if err := s.Expression.compile(compiler); err != nil {
+		return err
+	}
+	compiler.writeBytes(OpSetVariable, subjectIndex)
+	load := func() error {
+		compiler.writeBytes(OpReadVariable, subjectIndex)
+		return nil
+	}
+
+	// Each arm tests its patterns in order; a failed test jumps to the next pattern or arm, and the end of each
+	// arm body jumps to the end of the match statement
+	var endJumps []int
+	for _, arm := range s.Arms {
+		var failJumps, bodyJumps []int
+		for i, pattern := range arm.Patterns {
+			if err := compiler.patchJumps(failJumps); err != nil {
+				return err
+			}
+			failJumps = nil
+			if err := pattern.compileTest(compiler, load, &failJumps); err != nil {
+				return err
+			}
+			if i < len(arm.Patterns)-1 {
+				compiler.writeJump(OpJumpForward, &bodyJumps)
+			}
+		}
+		if err := compiler.patchJumps(bodyJumps); err != nil {
+			return err
+		}
+		if err := arm.Body.compile(compiler); err != nil {
+			return err
+		}
+		compiler.writeJump(OpJumpForward, &endJumps)
+		if err := compiler.patchJumps(failJumps); err != nil {
+			return err
+		}
+	}
+
+	if s.Otherwise != nil {
+		if err := s.Otherwise.compile(compiler); err != nil {
+			return err
+		}
+	}
+	return compiler.patchJumps(endJumps)
+}
+
+func (p *LiteralPattern) compileTest(compiler *Compiler, load func() error, failJumps *[]int) error {
+	if err := load(); err != nil {
+		return err
+	}
+	if err := p.Literal.compile(compiler); err != nil {
+		return err
+	}
+	compiler.writeBytes(OpBinary, OpBinaryEqual)
+	compiler.writeJump(OpJumpIfFalse, failJumps)
+	return nil
+}
+
+func (p *WildcardPattern) compileTest(compiler *Compiler, load func() error, failJumps *[]int) error {
+	return nil
+}
+
+func (p *BindingPattern) compileTest(compiler *Compiler, load func() error, failJumps *[]int) error {
+	index, err := compiler.registerVariable(p.Identifier.Lexeme)
+	if err != nil {
+		return err
+	}
+	if err := load(); err != nil {
+		return err
+	}
+	compiler.writeBytes(OpSetVariable, index)
+	return nil
+}
+
+func (p *TypePattern) compileTest(compiler *Compiler, load func() error, failJumps *[]int) error {
+	if err := load(); err != nil {
+		return err
+	}
+	typeNameIndex, err := compiler.ensureConstant(p.TypeName)
+	if err != nil {
+		return err
+	}
+	compiler.writeBytes(OpLoadConstant, typeNameIndex)
+	if err := compiler.writeBuiltinCall("isType", 2); err != nil {
+		return err
+	}
+	compiler.writeJump(OpJumpIfFalse, failJumps)
+
+	for _, field := range p.Fields {
+		variableIndex, err := compiler.registerVariable(field.Lexeme)
+		if err != nil {
+			return err
+		}
+		fieldIndex, err := compiler.ensureConstant(field.Lexeme)
+		if err != nil {
+			return err
+		}
+		if err := load(); err != nil {
+			return err
+		}
+		compiler.writeBytes(OpFieldAccess, fieldIndex, OpSetVariable, variableIndex)
+	}
+	return nil
+}
+
+func (p *ArrayPattern) compileTest(compiler *Compiler, load func() error, failJumps *[]int) error {
+	if err := load(); err != nil {
+		return err
+	}
+	compiler.writeBytes(OpMatchShape, byte(len(p.Elements)), byte(boolToInt(p.HasRest)))
+	compiler.writeJump(OpJumpIfFalse, failJumps)
+
+	for i, element := range p.Elements {
+		loadElement := func() error { // get(value, i)
+			if err := load(); err != nil {
+				return err
+			}
+			if err := intLiteral(i).compile(compiler); err != nil {
+				return err
+			}
+			return compiler.writeBuiltinCall("get", 2)
+		}
+		if err := element.compileTest(compiler, loadElement, failJumps); err != nil {
+			return err
+		}
+	}
+
+	if p.HasRest && p.Rest.Type != TokenUnderscore {
+		index, err := compiler.registerVariable(p.Rest.Lexeme)
+		if err != nil {
+			return err
+		}
+		if err := load(); err != nil { // slice(value, length, nil)
+			return err
+		}
+		if err := intLiteral(len(p.Elements)).compile(compiler); err != nil {
+			return err
+		}
+		compiler.writeByte(OpLoadNil)
+		if err := compiler.writeBuiltinCall("slice", 3); err != nil {
+			return err
+		}
+		compiler.writeBytes(OpSetVariable, index)
+	}
+	return nil
+}
+
+func intLiteral(i int) *LiteralExpression {
+	return &LiteralExpression{Token{Type: TokenNumber, Lexeme: strconv.Itoa(i), Literal: i}}
+}
+
+func (s *AttemptStatement) compile(compiler *Compiler) error {
+	// When an error happens after OpAttempt, the VM jumps to the failure block with the error on the stack;
+	// OpEndAttempt removes that failure handler again
+	var failureJumps, endJumps []int
+	compiler.writeJump(OpAttempt, &failureJumps)
+	compiler.attemptDepth += 1
+	if err := s.Body.compile(compiler); err != nil {
+		return err
+	}
+	compiler.attemptDepth -= 1
+	compiler.writeByte(OpEndAttempt)
+	compiler.writeJump(OpJumpForward, &endJumps)
+
+	if err := compiler.patchJumps(failureJumps); err != nil {
+		return err
+	}
+	if s.ErrorVariable != nil {
+		index, err := compiler.registerVariable(s.ErrorVariable.Lexeme)
+		if err != nil {
+			return err
+		}
+		compiler.writeBytes(OpSetVariable, index)
+	} else {
+		compiler.writeByte(OpPop)
+	}
+	if err := s.Failure.compile(compiler); err != nil {
+		return err
+	}
+	return compiler.patchJumps(endJumps)
+}
+
 func (s *ExitFunctionStatement) compile(compiler *Compiler) error {
+	compiler.writeEndAttempts(0)
 	compiler.exitFunctions = append(compiler.exitFunctions, compiler.len())
 	compiler.writeBytes(OpJumpForward, InvalidOp, InvalidOp)
 	return nil
 }
 
 func (s *ExitLoopStatement) compile(compiler *Compiler) error {
+	compiler.writeEndAttempts(compiler.currentLoopState().attemptDepth)
 	compiler.addExitLoop(compiler.len())
 	compiler.writeBytes(OpJumpForward, InvalidOp, InvalidOp)
 	return nil
 }
 
 func (s *NextIterationStatement) compile(compiler *Compiler) error {
+	compiler.writeEndAttempts(compiler.currentLoopState().attemptDepth)
 	compiler.addNextIteration(compiler.len())
 	// Jump type set in parseWhileStatement (back for while; forward for for)
 	compiler.writeBytes(InvalidOp, InvalidOp, InvalidOp)
@@ -248,18 +492,12 @@ func encodeJumpAmount(amount int) (byte, byte, error) {
 }
 
 func (s *FunctionDeclarationStatement) compile(compiler *Compiler) error {
-	hasOutVar := s.OutVariable != nil
-	outVarIdentifier := ""
-	if hasOutVar {
-		outVarIdentifier = s.OutVariable.Lexeme
-	}
-
 	functionVariables := make([]string, len(s.Parameters))
 	for i, param := range s.Parameters {
 		functionVariables[i] = param.Lexeme
 	}
-	if hasOutVar {
-		functionVariables = append(functionVariables, outVarIdentifier)
+	for _, outVariable := range s.OutVariables {
+		functionVariables = append(functionVariables, outVariable.Lexeme)
 	}
 
 	functionCompiler := &Compiler{constants: compiler.constants, functions: compiler.functions, variables: functionVariables}
@@ -285,7 +523,7 @@ func (s *FunctionDeclarationStatement) compile(compiler *Compiler) error {
 	for i, param := range s.Parameters {
 		params[i] = param.Lexeme
 	}
-	compiler.functions[s.Identifier.Lexeme] = VmFunction{params: params, ops: ops, variableDefinitions: functionCompiler.variables, hasOutVar: hasOutVar}
+	compiler.functions[s.Identifier.Lexeme] = VmFunction{params: params, ops: ops, variableDefinitions: functionCompiler.variables, outVarCount: len(s.OutVariables), positions: functionCompiler.positions}
 
 	return nil
 }
@@ -304,6 +542,28 @@ func (s *AssignmentStatement) compile(compiler *Compiler) error {
 	return nil
 }
 
+func (s *DestructuringAssignmentStatement) compile(compiler *Compiler) error {
+	indexes := make([]byte, len(s.Identifiers))
+	for i, identifier := range s.Identifiers {
+		index, err := compiler.registerVariable(identifier.Lexeme)
+		if err != nil {
+			return err
+		}
+		indexes[i] = index
+	}
+
+	if err := s.Expression.compile(compiler); err != nil {
+		return err
+	}
+
+	// Destructure pushes the values in reverse order, so we can set the variables from left to right
+	compiler.writeBytes(OpDestructure, byte(len(s.Identifiers)))
+	for _, index := range indexes {
+		compiler.writeBytes(OpSetVariable, index)
+	}
+	return nil
+}
+
 func (s *FieldAssignmentStatement) compile(compiler *Compiler) error {
 	if err := s.Left.compile(compiler); err != nil {
 		return err
@@ -320,6 +580,53 @@ func (s *FieldAssignmentStatement) compile(compiler *Compiler) error {
 	return nil
 }
 
+func (s *CompoundAssignmentStatement) compile(compiler *Compiler) error {
+	binaryOp, _, err := binaryOpcode(s.Operator)
+	if err != nil {
+		return err
+	}
+
+	switch target := s.Target.(type) {
+	case *ContainerAccessExpression:
+		// Container and key are evaluated once, and used by both get and set: container key container key
+		if err := target.Container.compile(compiler); err != nil {
+			return err
+		} else if err := target.Access.compile(compiler); err != nil {
+			return err
+		}
+		compiler.writeByte(OpDuplicatePair)
+		if err := compiler.writeBuiltinCall("get", 2); err != nil {
+			return err
+		} else if err := s.Expression.compile(compiler); err != nil {
+			return err
+		}
+		compiler.writeBytes(OpBinary, binaryOp)
+		if err := compiler.writeBuiltinCall("set", 3); err != nil {
+			return err
+		}
+		compiler.writeByte(OpPop)
+	case *FieldAccessExpression:
+		// The instance is evaluated once, and used by both the field access and set: instance instance
+		index, err := compiler.ensureConstant(target.Identifier.Lexeme)
+		if err != nil {
+			return err
+		}
+		if err := target.Left.compile(compiler); err != nil {
+			return err
+		}
+		compiler.writeByte(OpDuplicate)
+		compiler.writeBytes(OpFieldAccess, index)
+		if err := s.Expression.compile(compiler); err != nil {
+			return err
+		}
+		compiler.writeBytes(OpBinary, binaryOp)
+		compiler.writeBytes(OpSetField, index)
+	default:
+		return fmt.Errorf("unsupported compound assignment target '%T'", s.Target)
+	}
+	return nil
+}
+
 func (s *ExpressionStatement) compile(compiler *Compiler) error {
 	/* Discard return value afterwards using pop */
 	if err := s.Expression.compile(compiler); err != nil {
@@ -344,9 +651,21 @@ func (e *BinaryExpression) compile(compiler *Compiler) error {
 		return err
 	}
 
-	var binaryOp byte
-	appendNot := false
-	switch e.Operator.Type {
+	binaryOp, appendNot, err := binaryOpcode(e.Operator)
+	if err != nil {
+		return err
+	}
+
+	compiler.writeBytes(OpBinary, binaryOp)
+	if appendNot {
+		compiler.writeByte(OpNot)
+	}
+	return nil
+}
+
+// binaryOpcode returns the OpBinary operation for the operator, and whether the result needs to be negated
+func binaryOpcode(operator Token) (binaryOp byte, appendNot bool, err error) {
+	switch operator.Type {
 	case TokenPlus:
 		binaryOp = OpBinaryPlus
 	case TokenMinus:
@@ -363,6 +682,10 @@ func (e *BinaryExpression) compile(compiler *Compiler) error {
 		binaryOp = OpBinaryBinaryOr
 	case TokenXOr:
 		binaryOp = OpBinaryBinaryXor
+	case TokenShl:
+		binaryOp = OpBinaryShiftLeft
+	case TokenShr:
+		binaryOp = OpBinaryShiftRight
 
 	case TokenUnderscore:
 		binaryOp = OpBinaryConcat
@@ -383,28 +706,42 @@ func (e *BinaryExpression) compile(compiler *Compiler) error {
 		binaryOp = OpBinaryGreaterThan
 		appendNot = true
 	default:
-		return fmt.Errorf("unsupported binary operator %v ('%v')", e.Operator.Type, e.Operator.Lexeme)
+		return 0, false, fmt.Errorf("unsupported binary operator %v ('%v')", operator.Type, operator.Lexeme)
 	}
+	return binaryOp, appendNot, nil
+}
 
-	compiler.writeBytes(OpBinary, binaryOp)
-	if appendNot {
-		compiler.writeByte(OpNot)
+func (e *UnaryExpression) compile(compiler *Compiler) error {
+	if err := e.Operand.compile(compiler); err != nil {
+		return err
+	}
+
+	switch e.Operator.Type {
+	case TokenMinus:
+		compiler.writeByte(OpNegate)
+	case TokenBNot:
+		compiler.writeByte(OpBitwiseNot)
+	default:
+		return fmt.Errorf("unsupported unary operator %v ('%v')", e.Operator.Type, e.Operator.Lexeme)
 	}
 	return nil
 }
 
-func (e *BinaryExpression) compileOrOrAnd(compiler *Compiler, withNot bool) error {
+func (e *BinaryExpression) compileOrOrAnd(compiler *Compiler, isOr bool) error {
 	if err := e.Left.compile(compiler); err != nil {
 		return err
 	}
 
+	// When the left operand decides the outcome (true for 'or', false for 'and'), the jump leaves it on the stack
+	// as the result; otherwise it is popped and the right operand is the result
 	compiler.writeByte(OpDuplicate)
-	if withNot {
-		compiler.writeByte(OpNot)
+	jumpOp := OpJumpIfFalse
+	if isOr {
+		jumpOp = OpJumpIfTrue
 	}
 
 	jumpIndex := compiler.len()
-	compiler.writeBytes(OpJumpIfFalse, InvalidOp, InvalidOp, OpPop)
+	compiler.writeBytes(jumpOp, InvalidOp, InvalidOp, OpPop)
 
 	if err := e.Right.compile(compiler); err != nil {
 		return err
@@ -422,6 +759,27 @@ func (e *BinaryExpression) compileOrOrAnd(compiler *Compiler, withNot bool) erro
 	return nil
 }
 
+func (e *ConditionalExpression) compile(compiler *Compiler) error {
+	if err := e.Condition.compile(compiler); err != nil {
+		return err
+	}
+	var otherwiseJumps, endJumps []int
+	compiler.writeJump(OpJumpIfFalse, &otherwiseJumps)
+
+	if err := e.Then.compile(compiler); err != nil {
+		return err
+	}
+	compiler.writeJump(OpJumpForward, &endJumps)
+
+	if err := compiler.patchJumps(otherwiseJumps); err != nil {
+		return err
+	}
+	if err := e.Otherwise.compile(compiler); err != nil {
+		return err
+	}
+	return compiler.patchJumps(endJumps)
+}
+
 func (e *FieldAccessExpression) compile(compiler *Compiler) error {
 	if err := e.Left.compile(compiler); err != nil {
 		return err
@@ -451,35 +809,126 @@ func (e *FunctionCallExpression) compile(compiler *Compiler) error {
 		return fmt.Errorf("functions don't support more than 50 arguments (was %d for '%v')", len(e.Arguments), e.FunctionName)
 	}
 
+	if e.FieldIndexes != nil {
+		return e.compileReorderedArguments(compiler)
+	}
+
+	if e.Variable {
+		// Push the function value first, and the arguments on top of it
+		variable := &VariableExpression{Token: e.Token}
+		if err := variable.compile(compiler); err != nil {
+			return err
+		}
+	}
+
 	for _, arg := range e.Arguments {
 		if err := arg.compile(compiler); err != nil {
 			return err
 		}
 	}
 
+	if e.Variable {
+		compiler.writeBytes(OpCallValue, byte(len(e.Arguments)))
+		return nil
+	}
+
+	if e.Builtin {
+		return compiler.writeBuiltinCall(e.FunctionName, len(e.Arguments))
+	}
+
 	index, err := compiler.ensureConstant(e.FunctionName)
 	if err != nil {
 		return err
 	}
 
-	builtin, found := builtins[e.FunctionName]
-	if found && builtin.Arity == ArityVariadic {
-		compiler.writeBytes(OpCallVariadicFunction, index, byte(len(e.Arguments)))
-		return nil
-	}
-
 	op := OpCallFunction
-	if e.Builtin {
-		op = OpCallBuiltin
-	} else if e.Constructor {
+	if e.Constructor {
 		op = OpInstantiate
 	}
 	compiler.writeBytes(op, index)
 	return nil
 }
 
+func (e *MethodCallExpression) compile(compiler *Compiler) error {
+	if len(e.Arguments) > 49 {
+		return fmt.Errorf("methods don't support more than 49 arguments (was %d for '%v')", len(e.Arguments), e.Method.Lexeme)
+	}
+
+	// Push the receiver first, and the arguments on top of it
+	if err := e.Receiver.compile(compiler); err != nil {
+		return err
+	}
+	for _, arg := range e.Arguments {
+		if err := arg.compile(compiler); err != nil {
+			return err
+		}
+	}
+
+	index, err := compiler.ensureConstant(e.Method.Lexeme)
+	if err != nil {
+		return err
+	}
+	compiler.writeBytes(OpCallMethod, index, byte(len(e.Arguments)))
+	return nil
+}
+
+// compileReorderedArguments compiles a constructor call with arguments that are not in field order: the arguments are
+// evaluated in the order they are written into temporary variables, which are then pushed in field order
+func (e *FunctionCallExpression) compileReorderedArguments(compiler *Compiler) error {
+	compiler.constructorCount += 1
+	prefix := "_constructor_" + strconv.Itoa(compiler.constructorCount) + "_"
+	temporaries := make([]Token, len(e.Arguments))
+	for i, argument := range e.Arguments {
+		temporaries[e.FieldIndexes[i]] = Token{Type: TokenIdentifier, Lexeme: prefix + strconv.Itoa(e.FieldIndexes[i])}
+		assignment := &AssignmentStatement{Identifier: temporaries[e.FieldIndexes[i]], Expression: argument}
+		if err := assignment.compile(compiler); err != nil {
+			return err
+		}
+	}
+
+	arguments := make([]Expression, len(temporaries))
+	for i, temporary := range temporaries {
+		arguments[i] = &VariableExpression{Token: temporary}
+	}
+	call := *e
+	call.Arguments, call.FieldIndexes = arguments, nil
+	return call.compile(compiler)
+}
+
+func (e *FunctionReferenceExpression) compile(compiler *Compiler) error {
+	index, err := compiler.ensureConstant(e.FunctionName)
+	if err != nil {
+		return err
+	}
+
+	if e.Builtin {
+		compiler.writeBytes(OpBuiltinReference, index)
+	} else {
+		compiler.writeBytes(OpFunctionReference, index)
+	}
+	return nil
+}
+
+func (e *FunctionLiteralExpression) compile(compiler *Compiler) error {
+	if err := e.Declaration.compile(compiler); err != nil {
+		return err
+	}
+
+	index, err := compiler.ensureConstant(e.Declaration.Identifier.Lexeme)
+	if err != nil {
+		return err
+	}
+	compiler.writeBytes(OpFunctionReference, index)
+	return nil
+}
+
 func (e *LiteralExpression) compile(compiler *Compiler) error {
-	if i, ok := e.Token.Literal.(int); ok && i <= 0xFF {
+	if e.Token.Type == TokenNil {
+		compiler.writeBytes(OpLoadNil)
+		return nil
+	}
+
+	if i, ok := e.Token.Literal.(int); ok && i >= 0 && i <= 0xFF {
 		compiler.writeBytes(OpInlineNumber, byte(i))
 		return nil
 	}
@@ -505,6 +954,46 @@ func (e *VariableExpression) compile(compiler *Compiler) error {
 	return nil
 }
 
+func (e *RangeConditionExpression) compile(compiler *Compiler) error {
+	indexes := make([]byte, 3)
+	for i, tok := range []Token{e.Variable, e.End, e.Step} {
+		index, found := compiler.findVariableIndex(tok.Lexeme)
+		if !found {
+			return fmt.Errorf("variable '%v' used before set at %d:%d", tok.Lexeme, tok.Line, tok.Col)
+		}
+		indexes[i] = index
+	}
+	compiler.writeByte(OpRangeCheck)
+	compiler.writeBytes(indexes...)
+	return nil
+}
+
+func (e *IteratorExpression) compile(compiler *Compiler) error {
+	if err := e.Container.compile(compiler); err != nil {
+		return err
+	}
+	compiler.writeByte(OpIterator)
+	return nil
+}
+
+func (e *IteratorNextExpression) compile(compiler *Compiler) error {
+	iteratorIndex, found := compiler.findVariableIndex(e.Iterator.Lexeme)
+	if !found {
+		tok := e.Iterator
+		return fmt.Errorf("variable '%v' used before set at %d:%d", tok.Lexeme, tok.Line, tok.Col)
+	}
+	keyIndex, err := compiler.registerVariable(e.Key.Lexeme)
+	if err != nil {
+		return err
+	}
+	valueIndex, err := compiler.registerVariable(e.Value.Lexeme)
+	if err != nil {
+		return err
+	}
+	compiler.writeBytes(OpIteratorNext, iteratorIndex, keyIndex, valueIndex)
+	return nil
+}
+
 func combine(slices ...[]byte) []byte {
 	target := slices[0]
 	for i := 1; i < len(slices); i++ {
@@ -528,6 +1017,21 @@ func (c *Compiler) ensureConstant(value any) (byte, error) {
 	return byte(len(c.constants) - 1), nil
 }
 
+// writeBuiltinCall calls a builtin function with the arguments that are on the stack
+func (c *Compiler) writeBuiltinCall(name string, argumentCount int) error {
+	index, err := c.ensureConstant(name)
+	if err != nil {
+		return err
+	}
+
+	if builtins[name].Arity == ArityVariadic {
+		c.writeBytes(OpCallVariadicFunction, index, byte(argumentCount))
+	} else {
+		c.writeBytes(OpCallBuiltin, index)
+	}
+	return nil
+}
+
 func (c *Compiler) registerVariable(name string) (byte, error) {
 	for i, v := range c.variables {
 		if v == name {
@@ -40,6 +40,10 @@ func decompile(constants []any, ops []byte) {
 				fmt.Print(" LessThan")
 			case OpBinaryConcat:
 				fmt.Print(" Concat")
+			case OpBinaryShiftLeft:
+				fmt.Print(" ShiftLeft")
+			case OpBinaryShiftRight:
+				fmt.Print(" ShiftRight")
 			}
 		case OpNot:
 			fmt.Print("[1] Not")
@@ -54,6 +58,22 @@ func decompile(constants []any, ops []byte) {
 			i++
 			jumpAmount := num1*256 + num2
 			fmt.Printf("[3] JumpIfFalse +%d -> %d", jumpAmount, i+jumpAmount)
+		case OpAttempt:
+			num1 := int(ops[i])
+			i++
+			num2 := int(ops[i])
+			i++
+			jumpAmount := num1*256 + num2
+			fmt.Printf("[3] Attempt, failure at +%d -> %d", jumpAmount, i+jumpAmount)
+		case OpEndAttempt:
+			fmt.Print("[1] End attempt")
+		case OpJumpIfTrue:
+			num1 := int(ops[i])
+			i++
+			num2 := int(ops[i])
+			i++
+			jumpAmount := num1*256 + num2
+			fmt.Printf("[3] JumpIfTrue +%d -> %d", jumpAmount, i+jumpAmount)
 		case OpJumpForward:
 			num1 := int(ops[i])
 			i++
@@ -114,6 +134,53 @@ func decompile(constants []any, ops []byte) {
 			fmt.Printf("[2] Set field %d '%v'", index, constantValue)
 		case OpDuplicate:
 			fmt.Print("[1] Duplicate")
+		case OpFunctionReference:
+			index := ops[i]
+			i++
+			constantValue := constants[index]
+			fmt.Printf("[2] Function reference %d '%v'", index, constantValue)
+		case OpBuiltinReference:
+			index := ops[i]
+			i++
+			constantValue := constants[index]
+			fmt.Printf("[2] Builtin reference %d '%v'", index, constantValue)
+		case OpCallMethod:
+			index := ops[i]
+			i++
+			argCount := int(ops[i])
+			i++
+			constantValue := constants[index]
+			fmt.Printf("[3] Call method %d '%v' with %d arguments", index, constantValue, argCount)
+		case OpLoadNil:
+			fmt.Print("[1] Load nil")
+		case OpBitwiseNot:
+			fmt.Print("[1] Bitwise not")
+		case OpNegate:
+			fmt.Print("[1] Negate")
+		case OpDuplicatePair:
+			fmt.Print("[1] Duplicate pair")
+		case OpRangeCheck:
+			variable, end, step := ops[i], ops[i+1], ops[i+2]
+			i += 3
+			fmt.Printf("[4] Range check variable %d, end %d, step %d", variable, end, step)
+		case OpIterator:
+			fmt.Print("[1] Iterator")
+		case OpIteratorNext:
+			iterator, key, value := ops[i], ops[i+1], ops[i+2]
+			i += 3
+			fmt.Printf("[4] Iterator next %d into key %d, value %d", iterator, key, value)
+		case OpMatchShape:
+			length, hasRest := ops[i], ops[i+1]
+			i += 2
+			fmt.Printf("[3] Match shape of length %d (rest: %d)", length, hasRest)
+		case OpDestructure:
+			count := int(ops[i])
+			i++
+			fmt.Printf("[2] Destructure into %d values", count)
+		case OpCallValue:
+			argCount := int(ops[i])
+			i++
+			fmt.Printf("[2] Call value with %d arguments", argCount)
 		case InvalidOp:
 			fmt.Print("[1] !! Invalid op !!")
 		}
@@ -3,6 +3,7 @@ package main
 import (
 	"bufio"
 	"fmt"
+	"math/big"
 	"os"
 	"reflect"
 )
@@ -20,12 +21,8 @@ func dump(filename string, ops []byte, constants []any, variableDefinitions []st
 	fmt.Fprintf(w, "constants\n")
 	fmt.Fprintf(w, "%d\n", len(constants))
 	for _, v := range constants {
-		if num, ok := v.(int); ok {
-			fmt.Fprintf(w, "int:%d\n", num)
-		} else if str, ok := v.(string); ok {
-			fmt.Fprintf(w, "string:%s\n", str)
-		} else {
-			return fmt.Errorf("unsupported constant type %v for '%v'", reflect.TypeOf(v), v)
+		if err := dumpConstant(w, v); err != nil {
+			return err
 		}
 	}
 
@@ -33,7 +30,7 @@ func dump(filename string, ops []byte, constants []any, variableDefinitions []st
 	fmt.Fprintf(w, "%d\n", len(functions))
 	for name, f := range functions {
 		fmt.Fprintf(w, "%s\n", name)
-		fmt.Fprintf(w, "%t\n", f.hasOutVar)
+		fmt.Fprintf(w, "%d\n", f.outVarCount)
 		fmt.Fprintf(w, "parameters\n")
 		fmt.Fprintf(w, "%d\n", len(f.params))
 		for _, param := range f.params {
@@ -65,3 +62,25 @@ func dump(filename string, ops []byte, constants []any, variableDefinitions []st
 
 	return nil
 }
+
+// dumpConstant writes a constant on a line as type:value; a set is written as set:<size>, followed by its elements
+func dumpConstant(w *bufio.Writer, v any) error {
+	if num, ok := v.(int); ok {
+		fmt.Fprintf(w, "int:%d\n", num)
+	} else if b, ok := v.(*big.Int); ok {
+		fmt.Fprintf(w, "bigint:%s\n", b.String())
+	} else if str, ok := v.(string); ok {
+		fmt.Fprintf(w, "string:%s\n", str)
+	} else if set, ok := v.(*ToiSet); ok {
+		elements := set.elements.sortedKeys()
+		fmt.Fprintf(w, "set:%d\n", len(elements))
+		for _, element := range elements {
+			if err := dumpConstant(w, element); err != nil {
+				return err
+			}
+		}
+	} else {
+		return fmt.Errorf("unsupported constant type %v for '%v'", reflect.TypeOf(v), v)
+	}
+	return nil
+}
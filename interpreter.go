@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"errors"
 	"fmt"
+	"math/big"
 	"slices"
 )
 
@@ -34,6 +35,27 @@ func (instance *ToiInstance) print(out *bytes.Buffer) {
 	out.WriteRune('}')
 }
 
+// ToiFunction is a function value, created by a function reference or an anonymous function
+type ToiFunction struct {
+	declaration *FunctionDeclarationStatement
+	globals     Env
+}
+
+func (f *ToiFunction) call(arguments []any) (any, error) {
+	if len(arguments) != len(f.declaration.Parameters) {
+		return nil, fmt.Errorf("expected %d arguments but got %d for function '%s'", len(f.declaration.Parameters), len(arguments), f.name())
+	}
+	return callToiFunction(f.declaration, arguments, f.globals)
+}
+
+func (f *ToiFunction) name() string {
+	return f.declaration.Identifier.Lexeme
+}
+
+func (f *ToiFunction) print(out *bytes.Buffer) {
+	printFunction(f.name(), out)
+}
+
 const outerScope = "_outer"
 
 // TODO: global state is bad; also this seems rather inefficient
@@ -44,6 +66,27 @@ var currentInterpreterLineCol LineCol
 // Statements
 
 func (s *BlockStatement) execute(env Env) error {
+	currentInterpreterLineCol = s.lineCol()
+	for _, stmt := range s.Statements {
+		if err := stmt.execute(env); err != nil {
+			if lineCol := stmt.lineCol(); !isControlFlow(err) && lineCol.line != 0 {
+				// Statements generated by the parser have no position; their errors get the position of the
+				// statement they were generated for
+				return toToiError(err, lineCol)
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// isControlFlow returns whether the error is not an actual error, but used for exit loop, next iteration, or exit
+// function
+func isControlFlow(err error) bool {
+	return errors.Is(err, ErrExitLoop) || errors.Is(err, ErrNextIteration) || errors.Is(err, ErrExitFunction)
+}
+
+func (s *ImportStatement) execute(env Env) error {
 	currentInterpreterLineCol = s.lineCol()
 	for _, stmt := range s.Statements {
 		if err := stmt.execute(env); err != nil {
@@ -102,6 +145,112 @@ func (s *WhileStatement) execute(env Env) error {
 	return nil
 }
 
+func (s *AttemptStatement) execute(env Env) error {
+	currentInterpreterLineCol = s.lineCol()
+	err := s.Body.execute(env)
+	if err == nil || isControlFlow(err) {
+		return err
+	}
+
+	if s.ErrorVariable != nil {
+		env[s.ErrorVariable.Lexeme] = toToiError(err, s.lineCol())
+	}
+	return s.Failure.execute(env)
+}
+
+func (s *MatchStatement) execute(env Env) error {
+	currentInterpreterLineCol = s.lineCol()
+	v, err := s.Expression.evaluate(env)
+	if err != nil {
+		return err
+	}
+
+	for _, arm := range s.Arms {
+		for _, pattern := range arm.Patterns {
+			matched, err := pattern.matches(v, env)
+			if err != nil {
+				return err
+			} else if matched {
+				return arm.Body.execute(env)
+			}
+		}
+	}
+	if s.Otherwise != nil {
+		return s.Otherwise.execute(env)
+	}
+	return nil
+}
+
+func (p *LiteralPattern) matches(v any, env Env) (bool, error) {
+	literal, err := p.Literal.evaluate(env)
+	if err != nil {
+		return false, err
+	}
+	return isEqual(v, literal), nil
+}
+
+func (p *WildcardPattern) matches(v any, env Env) (bool, error) {
+	return true, nil
+}
+
+func (p *BindingPattern) matches(v any, env Env) (bool, error) {
+	env[p.Identifier.Lexeme] = v
+	return true, nil
+}
+
+func (p *TypePattern) matches(v any, env Env) (bool, error) {
+	if typeName(v) != p.TypeName {
+		return false, nil
+	}
+	for _, field := range p.Fields {
+		// The parser only allows fields for custom types
+		instance := v.(*ToiInstance)
+		index, found := instance.toiType.FieldMap[field.Lexeme]
+		if !found {
+			return false, fmt.Errorf("field '%v' not found on type '%v'", field.Lexeme, p.TypeName)
+		}
+		env[field.Lexeme] = instance.fieldValues[index]
+	}
+	return true, nil
+}
+
+func (p *ArrayPattern) matches(v any, env Env) (bool, error) {
+	if !matchesShape(v, len(p.Elements), p.HasRest) {
+		return false, nil
+	}
+	elements, _ := arrayElements(v)
+	for i, element := range p.Elements {
+		if matched, err := element.matches(elements[i], env); err != nil || !matched {
+			return false, err
+		}
+	}
+	if p.HasRest && p.Rest.Type != TokenUnderscore {
+		rest, err := builtinSliceVm([]any{v, len(p.Elements), nil})
+		if err != nil {
+			return false, err
+		}
+		env[p.Rest.Lexeme] = rest
+	}
+	return true, nil
+}
+
+// arrayElements returns the elements of an array or tuple
+func arrayElements(v any) ([]any, bool) {
+	switch value := v.(type) {
+	case *[]any:
+		return *value, true
+	case *Tuple:
+		return value.values, true
+	}
+	return nil, false
+}
+
+// matchesShape returns whether v is an array or tuple with length elements, or more than that if hasRest is true
+func matchesShape(v any, length int, hasRest bool) bool {
+	elements, ok := arrayElements(v)
+	return ok && (len(elements) == length || (hasRest && len(elements) > length))
+}
+
 func (s *ExitFunctionStatement) execute(env Env) error {
 	currentInterpreterLineCol = s.lineCol()
 	return ErrExitFunction
@@ -135,6 +284,22 @@ func (s *AssignmentStatement) execute(env Env) error {
 	return nil
 }
 
+func (s *DestructuringAssignmentStatement) execute(env Env) error {
+	currentInterpreterLineCol = s.lineCol()
+	v, err := s.Expression.evaluate(env)
+	if err != nil {
+		return err
+	}
+	values, err := destructure(v, len(s.Identifiers))
+	if err != nil {
+		return err
+	}
+	for i, identifier := range s.Identifiers {
+		env[identifier.Lexeme] = values[i]
+	}
+	return nil
+}
+
 func (s *FieldAssignmentStatement) execute(env Env) error {
 	left, err := s.Left.evaluate(env)
 	if err != nil {
@@ -142,7 +307,7 @@ func (s *FieldAssignmentStatement) execute(env Env) error {
 	}
 	instance, ok := left.(*ToiInstance)
 	if !ok {
-		return fmt.Errorf("left-hand operand of '.' must be a type instance but was '%v'", left)
+		return fmt.Errorf("left-hand operand of '.' must be a type instance but was '%v'", formatValue(left))
 	}
 	value, err := s.Expression.evaluate(env)
 	if err != nil {
@@ -157,6 +322,58 @@ func (s *FieldAssignmentStatement) execute(env Env) error {
 	return nil
 }
 
+func (s *CompoundAssignmentStatement) execute(env Env) error {
+	currentInterpreterLineCol = s.lineCol()
+	switch target := s.Target.(type) {
+	case *ContainerAccessExpression:
+		arguments, err := toArguments(env, []Expression{target.Container, target.Access})
+		if err != nil {
+			return err
+		}
+		current, err := builtins["get"].VmFunc(arguments)
+		if err != nil {
+			return err
+		}
+		result, err := s.evaluateWith(env, current)
+		if err != nil {
+			return err
+		}
+		_, err = builtins["set"].VmFunc(append(arguments, result))
+		return err
+	case *FieldAccessExpression:
+		left, err := target.Left.evaluate(env)
+		if err != nil {
+			return err
+		}
+		instance, ok := left.(*ToiInstance)
+		if !ok {
+			return fmt.Errorf("left-hand operand of '.' must be a type instance but was '%v'", formatValue(left))
+		}
+		index, found := instance.toiType.FieldMap[target.Identifier.Lexeme]
+		if !found {
+			return fmt.Errorf("field '%v' not found on type '%v'", target.Identifier.Lexeme, instance.toiType.Identifier.Lexeme)
+		}
+		result, err := s.evaluateWith(env, instance.fieldValues[index])
+		if err != nil {
+			return err
+		}
+		instance.fieldValues[index] = result
+		return nil
+	}
+	return fmt.Errorf("unsupported compound assignment target '%T'", s.Target)
+}
+
+// evaluateWith evaluates the right-hand side of the compound assignment, and applies the operator to the current
+// value of the target and the result
+func (s *CompoundAssignmentStatement) evaluateWith(env Env, current any) (any, error) {
+	value, err := s.Expression.evaluate(env)
+	if err != nil {
+		return nil, err
+	}
+	currentInterpreterLineCol = s.Operator.LineCol()
+	return binaryOperation(s.Operator, current, value)
+}
+
 func (s *ExpressionStatement) execute(env Env) error {
 	currentInterpreterLineCol = s.lineCol()
 	_, err := s.Expression.evaluate(env) /* Discard return value */
@@ -183,26 +400,14 @@ func (e *BinaryExpression) evaluate(env Env) (any, error) {
 		return nil, err
 	}
 
-	token := e.Operator
-	operator := token.Lexeme
+	return binaryOperation(e.Operator, left, right)
+}
 
-	switch e.Operator.Type {
-	case TokenPlus:
-		return intBinaryOp(left, right, operator, func(l int, r int) int { return l + r })
-	case TokenMinus:
-		return intBinaryOp(left, right, operator, func(l int, r int) int { return l - r })
-	case TokenAsterisk:
-		return intBinaryOp(left, right, operator, func(l int, r int) int { return l * r })
-	case TokenSlash:
-		return intBinaryOp(left, right, operator, func(l int, r int) int { return l / r })
-	case TokenPercent:
-		return intBinaryOp(left, right, operator, func(l int, r int) int { return l % r })
-	case TokenBAnd:
-		return intBinaryOp(left, right, operator, func(l int, r int) int { return l & r })
-	case TokenBOr:
-		return intBinaryOp(left, right, operator, func(l int, r int) int { return l | r })
-	case TokenXOr:
-		return intBinaryOp(left, right, operator, func(l int, r int) int { return l ^ r })
+func binaryOperation(token Token, left, right any) (any, error) {
+	operator := token.Lexeme
+	switch token.Type {
+	case TokenPlus, TokenMinus, TokenAsterisk, TokenSlash, TokenPercent, TokenBAnd, TokenBOr, TokenXOr, TokenShl, TokenShr:
+		return arithmetic(left, right, operator)
 
 	case TokenUnderscore:
 		return stringConcat(left, right)
@@ -210,92 +415,148 @@ func (e *BinaryExpression) evaluate(env Env) (any, error) {
 	case TokenEqualEqual:
 		return boolToInt(isEqual(left, right)), nil
 	case TokenNotEqual:
-		return boolToInt(left != right), nil
+		return boolToInt(!isEqual(left, right)), nil
 	case TokenGreaterThan:
-		return intBinaryOp(left, right, operator, func(l int, r int) int { return boolToInt(l > r) })
+		return compareNumbers(left, right, operator, func(c int) bool { return c > 0 })
 	case TokenGreaterEqual:
-		return intBinaryOp(left, right, operator, func(l int, r int) int { return boolToInt(l >= r) })
+		return compareNumbers(left, right, operator, func(c int) bool { return c >= 0 })
 	case TokenLessThan:
-		return intBinaryOp(left, right, operator, func(l int, r int) int { return boolToInt(l < r) })
+		return compareNumbers(left, right, operator, func(c int) bool { return c < 0 })
 	case TokenLessEqual:
-		return intBinaryOp(left, right, operator, func(l int, r int) int { return boolToInt(l <= r) })
+		return compareNumbers(left, right, operator, func(c int) bool { return c <= 0 })
 	}
 
 	return nil, fmt.Errorf("unsupported binary operator %v ('%v')", token.Type, token.Lexeme)
 }
 
-func (e *BinaryExpression) evaluateOrOrAnd(env Env, testFunc func(v any) bool) (any, error) {
-	currentInterpreterLineCol = e.lineCol()
-	left, err := e.Left.evaluate(env)
+func (e *ConditionalExpression) evaluate(env Env) (any, error) {
+	condition, err := e.Condition.evaluate(env)
 	if err != nil {
 		return nil, err
 	}
+	if isWeirdlyTrue(condition) {
+		return e.Then.evaluate(env)
+	}
+	return e.Otherwise.evaluate(env)
+}
 
-	leftInt, err := castToInt(left, "left", e.Operator.Lexeme)
+func (e *UnaryExpression) evaluate(env Env) (any, error) {
+	currentInterpreterLineCol = e.lineCol()
+	operand, err := e.Operand.evaluate(env)
 	if err != nil {
 		return nil, err
 	}
+	return unaryArithmetic(operand, e.Operator.Lexeme)
+}
 
-	if testFunc(leftInt) {
-		return leftInt, nil
-	}
-
-	right, err := e.Right.evaluate(env)
+func (e *BinaryExpression) evaluateOrOrAnd(env Env, testFunc func(v any) bool) (any, error) {
+	currentInterpreterLineCol = e.lineCol()
+	left, err := e.Left.evaluate(env)
 	if err != nil {
 		return nil, err
 	}
 
-	rightInt, err := castToInt(right, "right", e.Operator.Lexeme)
-	if err != nil {
-		return nil, err
+	// The left operand is the result when it decides the outcome (true for 'or', false for 'and'), without
+	// evaluating the right operand; otherwise the result is the right operand
+	if testFunc(left) {
+		return left, nil
 	}
+	return e.Right.evaluate(env)
+}
 
-	return rightInt, nil
+// isEqual compares values structurally, so e.g. arrays, maps, and instances with equal contents are equal
+func isEqual(left, right any) bool {
+	return (&equality{}).isEqual(left, right)
 }
 
-func intBinaryOp(left, right any, operator string, op func(int, int) int) (any, error) {
-	leftInt, err := castToInt(left, "left", operator)
-	if err != nil {
-		return nil, err
-	}
+// equality keeps track of the pairs of containers that are being compared, so that comparing containers that
+// (indirectly) contain themselves ends instead of recursing forever
+type equality struct {
+	depth     int
+	comparing map[[2]any]struct{}
+}
 
-	rightInt, err := castToInt(right, "right", operator)
-	if err != nil {
-		return nil, err
-	}
+// untrackedEqualityDepth is how deeply containers are nested before they are tracked; most values are not nested
+// deeply, and are compared without the cost of tracking them
+const untrackedEqualityDepth = 32
 
-	return op(leftInt, rightInt), nil
+func (e *equality) isEqual(left, right any) bool {
+	switch l := left.(type) {
+	case *[]any:
+		if r, ok := right.(*[]any); ok {
+			return l == r || e.isContentEqual(l, r, func() bool { return slices.EqualFunc(*l, *r, e.isEqual) })
+		}
+	case *Tuple:
+		if r, ok := right.(*Tuple); ok {
+			return e.isContentEqual(l, r, func() bool { return slices.EqualFunc(l.values, r.values, e.isEqual) })
+		}
+	case *ToiInstance:
+		if r, ok := right.(*ToiInstance); ok {
+			return l == r || l.toiType == r.toiType && e.isContentEqual(l, r, func() bool { return slices.EqualFunc(l.fieldValues, r.fieldValues, e.isEqual) })
+		}
+	case *VmInstance:
+		if r, ok := right.(*VmInstance); ok {
+			return l == r || l.vmType.Name == r.vmType.Name && e.isContentEqual(l, r, func() bool { return slices.EqualFunc(l.values, r.values, e.isEqual) })
+		}
+	case *big.Int:
+		if r, ok := right.(*big.Int); ok {
+			return l.Cmp(r) == 0
+		}
+	case *ToiMap:
+		if r, ok := right.(*ToiMap); ok {
+			return l == r || e.isContentEqual(l, r, func() bool { return e.isMapEqual(l, r) })
+		}
+	case *ToiSet:
+		if r, ok := right.(*ToiSet); ok {
+			return l == r || e.isContentEqual(l, r, func() bool { return e.isMapEqual(l.elements, r.elements) })
+		}
+	}
+	return left == right
 }
 
-func isEqual(left, right any) bool {
-	leftArray, ok := left.(*[]any)
-	if ok {
-		rightArray, ok := right.(*[]any)
-		if ok {
-			return slices.Equal(*leftArray, *rightArray)
-		}
+// isContentEqual compares the contents of two containers, unless they are already being compared: a cycle back to
+// the same pair cannot make them different, so the comparison further up decides
+func (e *equality) isContentEqual(left, right any, compareContents func() bool) bool {
+	if e.depth < untrackedEqualityDepth {
+		e.depth += 1
+		equal := compareContents()
+		e.depth -= 1
+		return equal
 	}
 
-	return left == right
+	pair := [2]any{left, right}
+	if _, found := e.comparing[pair]; found {
+		return true
+	}
+	if e.comparing == nil {
+		e.comparing = make(map[[2]any]struct{})
+	}
+	e.comparing[pair] = struct{}{}
+	return compareContents()
 }
 
-func castToInt(v any, side, operator string) (int, error) {
-	int, ok := v.(int)
-	if !ok {
-		return 0, fmt.Errorf("%s-hand operand of '%s' should be an int but was '%v'", side, operator, v)
+func (e *equality) isMapEqual(left, right *ToiMap) bool {
+	if left.len() != right.len() {
+		return false
+	}
+	for hash, leftEntry := range left.entries {
+		rightEntry, found := right.entries[hash]
+		if !found || !e.isEqual(leftEntry.value, rightEntry.value) {
+			return false
+		}
 	}
-	return int, nil
+	return true
 }
 
 func stringConcat(left, right any) (any, error) {
 	leftString, ok := left.(string)
 	if !ok {
-		return nil, fmt.Errorf("left-hand operand of '_' should be a string but was '%v'", left)
+		return nil, fmt.Errorf("left-hand operand of '_' should be a string but was '%v'", formatValue(left))
 	}
 
 	rightString, ok := right.(string)
 	if !ok {
-		return nil, fmt.Errorf("right-hand operand of '_' should be a string but was '%v'", right)
+		return nil, fmt.Errorf("right-hand operand of '_' should be a string but was '%v'", formatValue(right))
 	}
 
 	return leftString + rightString, nil
@@ -306,18 +567,65 @@ func (e *FieldAccessExpression) evaluate(env Env) (any, error) {
 	if err != nil {
 		return nil, err
 	}
+	if toiError, ok := left.(*ToiError); ok {
+		return toiError.field(e.Identifier.Lexeme)
+	}
 	instance, ok := left.(*ToiInstance)
 	if !ok {
-		return nil, fmt.Errorf("left-hand operand of '.' must be a type instance but was '%v'", left)
+		return nil, fmt.Errorf("left-hand operand of '.' must be a type instance but was '%v'", formatValue(left))
 	}
 	identifier := e.Identifier.Lexeme
 	index, found := instance.toiType.FieldMap[identifier]
 	if !found {
+		globals := getGlobals(env)
+		if method := findToiMethod(instance, identifier, globals); method != nil {
+			// instance.method without calling it results in a function value bound to the instance
+			return &BoundMethod{name: method.Identifier.Lexeme, receiver: instance, method: &ToiFunction{declaration: method, globals: globals}}, nil
+		}
 		return nil, fmt.Errorf("field '%v' not found on type '%v'", e.Identifier.Lexeme, instance.toiType.Identifier.Lexeme)
 	}
 	return instance.fieldValues[index], nil
 }
 
+func (e *MethodCallExpression) evaluate(env Env) (any, error) {
+	receiver, err := e.Receiver.evaluate(env)
+	if err != nil {
+		return nil, err
+	}
+	currentInterpreterLineCol = e.lineCol()
+	instance, ok := receiver.(*ToiInstance)
+	if !ok {
+		return nil, fmt.Errorf("left-hand operand of '.' must be a type instance but was '%v'", formatValue(receiver))
+	}
+	arguments, err := toArguments(env, e.Arguments)
+	if err != nil {
+		return nil, err
+	}
+
+	methodName := e.Method.Lexeme
+	globals := getGlobals(env)
+	if method := findToiMethod(instance, methodName, globals); method != nil {
+		if len(arguments) != len(method.Parameters)-1 {
+			return nil, fmt.Errorf("expected %d arguments but got %d for method '%s'", len(method.Parameters)-1, len(arguments), method.Identifier.Lexeme)
+		}
+		return callToiFunction(method, append([]any{instance}, arguments...), globals)
+	}
+
+	// A field holding a function value can be called like a method (but without a receiver)
+	if index, found := instance.toiType.FieldMap[methodName]; found {
+		if function, ok := instance.fieldValues[index].(callable); ok {
+			return function.call(arguments)
+		}
+	}
+	return nil, fmt.Errorf("method '%v' not found on type '%v'", methodName, instance.toiType.Identifier.Lexeme)
+}
+
+// findToiMethod returns the method with the given name of the type of the instance, or nil if there is none
+func findToiMethod(instance *ToiInstance, methodName string, globals Env) *FunctionDeclarationStatement {
+	method, _ := globals[getFuncEnvName(instance.toiType.Identifier.Lexeme+"."+methodName)].(*FunctionDeclarationStatement)
+	return method
+}
+
 func (e *ContainerAccessExpression) evaluate(env Env) (any, error) {
 	currentInterpreterLineCol = e.lineCol()
 	get := builtins["get"]
@@ -331,53 +639,51 @@ func (e *FunctionCallExpression) evaluate(env Env) (any, error) {
 		return builtin.Func(env, e.Arguments)
 	}
 
-	var envToFindFunc = env
-	if outer, found := env[outerScope]; found {
-		envToFindFunc = outer.(Env)
-	}
-	stmt := envToFindFunc[getFuncEnvName(e.FunctionName)]
-
-	if fff, ok := stmt.(*FunctionDeclarationStatement); ok {
-		funcStmt := fff
-		functionEnv := make(Env)
-		if outer, found := env[outerScope]; found {
-			functionEnv[outerScope] = outer
-		} else {
-			functionEnv[outerScope] = env
+	if e.Variable {
+		value, found := env[e.FunctionName]
+		if !found {
+			return nil, undefinedVariableError(e.FunctionName)
 		}
-
-		if funcStmt.OutVariable != nil {
-			functionEnv[funcStmt.OutVariable.Lexeme] = nil
-		}
-		var err error
-		for i, param := range funcStmt.Parameters {
-			functionEnv[param.Lexeme], err = e.Arguments[i].evaluate(env)
-			if err != nil {
-				return nil, err
-			}
+		function, ok := value.(callable)
+		if !ok {
+			return nil, notAFunctionError(value)
 		}
-		if err = funcStmt.Body.execute(functionEnv); err != nil {
-			if !errors.Is(err, ErrExitFunction) {
-				return nil, err
-			}
+		arguments, err := toArguments(env, e.Arguments)
+		if err != nil {
+			return nil, err
 		}
-		if funcStmt.OutVariable != nil {
-			return functionEnv[funcStmt.OutVariable.Lexeme], nil
+		return function.call(arguments)
+	}
+
+	globals := getGlobals(env)
+	stmt := globals[getFuncEnvName(e.FunctionName)]
+
+	if funcStmt, ok := stmt.(*FunctionDeclarationStatement); ok {
+		arguments, err := toArguments(env, e.Arguments)
+		if err != nil {
+			return nil, err
 		}
-		return nil, nil
+		return callToiFunction(funcStmt, arguments, globals)
 	} else {
-		typeStmt := stmt.(*TypeStatement)
+		typeStmt, ok := stmt.(*TypeStatement)
+		if !ok {
+			return nil, fmt.Errorf("type '%s' is not declared yet", e.FunctionName)
+		}
 		if len(typeStmt.Fields) != len(e.Arguments) {
-			panic(fmt.Sprintf("%d != %d", len(typeStmt.Fields), len(e.Arguments)))
+			return nil, fmt.Errorf("expected %d arguments but got %d for type '%s'", len(typeStmt.Fields), len(e.Arguments), typeStmt.Identifier.Lexeme)
 		}
 
 		fieldValues := make([]any, len(typeStmt.Fields))
-		for i := range typeStmt.Fields {
-			value, err := e.Arguments[i].evaluate(env)
+		for i, argument := range e.Arguments {
+			value, err := argument.evaluate(env)
 			if err != nil {
 				return nil, err
 			}
-			fieldValues[i] = value
+			if e.FieldIndexes != nil {
+				fieldValues[e.FieldIndexes[i]] = value
+			} else {
+				fieldValues[i] = value
+			}
 		}
 
 		return &ToiInstance{
@@ -387,6 +693,53 @@ func (e *FunctionCallExpression) evaluate(env Env) (any, error) {
 	}
 }
 
+func callToiFunction(funcStmt *FunctionDeclarationStatement, arguments []any, globals Env) (any, error) {
+	functionEnv := make(Env)
+	functionEnv[outerScope] = globals
+
+	for _, outVariable := range funcStmt.OutVariables {
+		functionEnv[outVariable.Lexeme] = nil
+	}
+	for i, param := range funcStmt.Parameters {
+		functionEnv[param.Lexeme] = arguments[i]
+	}
+	if err := funcStmt.Body.execute(functionEnv); err != nil {
+		if !errors.Is(err, ErrExitFunction) {
+			return nil, err
+		}
+	}
+	if len(funcStmt.OutVariables) == 1 {
+		return functionEnv[funcStmt.OutVariables[0].Lexeme], nil
+	} else if len(funcStmt.OutVariables) > 1 {
+		// Multiple out-variables are returned as a tuple
+		values := make([]any, len(funcStmt.OutVariables))
+		for i, outVariable := range funcStmt.OutVariables {
+			values[i] = functionEnv[outVariable.Lexeme]
+		}
+		return &Tuple{values: values}, nil
+	}
+	return nil, nil
+}
+
+func (e *FunctionReferenceExpression) evaluate(env Env) (any, error) {
+	currentInterpreterLineCol = e.lineCol()
+	if e.Builtin {
+		return &BuiltinFunctionValue{name: e.FunctionName, builtin: builtins[e.FunctionName]}, nil
+	}
+
+	globals := getGlobals(env)
+	funcStmt, ok := globals[getFuncEnvName(e.FunctionName)].(*FunctionDeclarationStatement)
+	if !ok {
+		return nil, fmt.Errorf("function '%s' not declared", e.FunctionName)
+	}
+	return &ToiFunction{declaration: funcStmt, globals: globals}, nil
+}
+
+func (e *FunctionLiteralExpression) evaluate(env Env) (any, error) {
+	currentInterpreterLineCol = e.lineCol()
+	return &ToiFunction{declaration: e.Declaration, globals: getGlobals(env)}, nil
+}
+
 func (e *LiteralExpression) evaluate(env Env) (any, error) {
 	currentInterpreterLineCol = e.lineCol()
 	return e.Token.Literal, nil
@@ -399,11 +752,53 @@ func (e *VariableExpression) evaluate(env Env) (any, error) {
 	if found {
 		return val, nil
 	}
-	return nil, fmt.Errorf("undefined variable '%s'", identifier)
+	return nil, undefinedVariableError(identifier)
+}
+
+func (e *RangeConditionExpression) evaluate(env Env) (any, error) {
+	currentInterpreterLineCol = e.lineCol()
+	continues, err := rangeContinues(env[e.Variable.Lexeme], env[e.End.Lexeme], env[e.Step.Lexeme])
+	if err != nil {
+		return nil, err
+	}
+	return boolToInt(continues), nil
+}
+
+func (e *IteratorExpression) evaluate(env Env) (any, error) {
+	container, err := e.Container.evaluate(env)
+	if err != nil {
+		return nil, err
+	}
+	currentInterpreterLineCol = e.lineCol()
+	globals := getGlobals(env)
+	return newIterator(container, func(v any, name string) (callable, bool) {
+		instance, ok := v.(*ToiInstance)
+		if !ok {
+			return nil, false
+		}
+		method := findToiMethod(instance, name, globals)
+		if method == nil {
+			return nil, false
+		}
+		return &BoundMethod{name: method.Identifier.Lexeme, receiver: instance, method: &ToiFunction{declaration: method, globals: globals}}, true
+	})
+}
+
+func (e *IteratorNextExpression) evaluate(env Env) (any, error) {
+	currentInterpreterLineCol = e.lineCol()
+	key, value, ok, err := env[e.Iterator.Lexeme].(iterator).next()
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		env[e.Key.Lexeme] = key
+		env[e.Value.Lexeme] = value
+	}
+	return boolToInt(ok), nil
 }
 
 func isWeirdlyTrue(v any) bool {
-	return v != 0
+	return v != 0 && v != nil
 }
 
 func boolToInt(b bool) int {
@@ -421,6 +816,14 @@ func intToBool(i int) bool {
 	return true
 }
 
+// getGlobals returns the global environment, which is where functions and types are declared
+func getGlobals(env Env) Env {
+	if outer, found := env[outerScope]; found {
+		return outer.(Env)
+	}
+	return env
+}
+
 func getFuncEnvName(identifier string) string {
 	return "_func_" + identifier
 }
@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"path/filepath"
+	"regexp"
 	"time"
 )
 
@@ -35,7 +37,7 @@ func main() {
 	var scriptName string
 	if len(args) == 0 {
 		scriptName = "(stdin)"
-		stdout, err = runScript(stdin, outFile, "")
+		stdout, err = runScript(stdin, "", outFile, "")
 	} else if len(args) == 1 {
 		scriptName = args[0]
 		stdout, err = runScriptFile(scriptName, outFile, string(stdin))
@@ -49,7 +51,7 @@ func main() {
 	return
 }
 
-func runScript(scriptData []byte, outFile string, stdin string) (string, error) {
+func runScript(scriptData []byte, scriptPath string, outFile string, stdin string) (string, error) {
 	tokens, errors := tokenize(string(scriptData))
 	if len(errors) != 0 {
 		fmt.Fprintf(os.Stderr, "Got %d errors:\n", len(errors))
@@ -59,7 +61,25 @@ func runScript(scriptData []byte, outFile string, stdin string) (string, error)
 		return "", fmt.Errorf("tokenization error")
 	}
 
-	parser := &Parser{tokens: tokens, declaredFunctions: make(map[string]int), declaredTypes: make(map[string]struct{})}
+	imports := &Imports{modules: make(map[string]*ImportedModule), declared: make(map[string]struct{})}
+	directory := ""
+	if scriptPath != "" {
+		absPath, err := filepath.Abs(scriptPath)
+		if err != nil {
+			return "", err
+		}
+		imports.parsing = append(imports.parsing, absPath)
+		directory = filepath.Dir(absPath)
+	}
+
+	parser := &Parser{
+		tokens:            tokens,
+		directory:         directory,
+		imports:           imports,
+		namespaces:        make(map[string]struct{}),
+		declaredFunctions: make(map[string]int),
+		declaredTypes:     make(map[string]*TypeStatement),
+	}
 	scriptStatement, err := parser.parse()
 	if err != nil {
 		return "", fmt.Errorf("parse error: %w", err)
@@ -68,6 +88,7 @@ func runScript(scriptData []byte, outFile string, stdin string) (string, error)
 	// TODO: better state management instead of globals
 	toiStdin = stdin
 	toiStdout = &bytes.Buffer{}
+	regexCache = make(map[string]*regexp.Regexp)
 
 	vars := make(map[string]any)
 
@@ -98,7 +119,7 @@ func runScript(scriptData []byte, outFile string, stdin string) (string, error)
 	}
 
 	start = time.Now()
-	err = execute(ops, compiler.constants, compiler.variables, compiler.functions, compiler.declaredTypes)
+	err = execute(ops, compiler.constants, compiler.variables, compiler.functions, compiler.declaredTypes, compiler.positions)
 	if err != nil {
 		fmt.Printf("%s\n", toiStdout.String())
 		return "", fmt.Errorf("VM execution error: %w", err)
@@ -128,7 +149,7 @@ func runScriptFile(filepath string, outFile string, stdin string) (string, error
 		return "", err
 	}
 
-	return runScript(scriptData, outFile, stdin)
+	return runScript(scriptData, filepath, outFile, stdin)
 }
 
 func ohno(err error) {
@@ -1,26 +1,78 @@
 package main
 
 import (
+	"errors"
 	"fmt"
+	"os"
+	"path/filepath"
 	"reflect"
+	"slices"
 	"strconv"
+	"strings"
 )
 
+// ForwardReference is a reference to a function (&name) that is resolved once all functions are declared
+type ForwardReference struct {
+	Token     Token
+	Reference *FunctionReferenceExpression
+}
+
 type ForwardCall struct {
 	Token         Token
 	ArgumentCount int
+	ArgumentNames []Token
+	Call          *FunctionCallExpression
+	Variables     map[string]struct{} // the variables of the function (or script) containing the call
+}
+
+// ImportedModule contains the names a parsed import makes available to the importing file
+type ImportedModule struct {
+	functions  map[string]int
+	types      map[string]*TypeStatement
+	namespaces map[string]struct{}
+}
+
+// Imports is the state shared by the parsers of a script and all the files it (transitively) imports
+type Imports struct {
+	parsing  []string                   // files currently being parsed, to detect import cycles
+	modules  map[string]*ImportedModule // by file path and namespace, so each is only parsed once
+	declared map[string]struct{}        // fully qualified names of all declared functions and types
+
+	anonymousFunctions int // to give every anonymous function a unique name
+}
+
+// MethodDeclaration is a method declared on a custom type, e.g. Item.describe
+type MethodDeclaration struct {
+	Type   Token
+	Method Token
 }
 
+// receiverName is the name of the implicit parameter holding the instance a method is called on
+const receiverName = "this"
+
+// anonymousFunctionPrefix cannot be part of an identifier, so anonymous function names never clash with declared ones
+const anonymousFunctionPrefix = "anonymous#"
+
 type Parser struct {
 	tokens []Token
 
+	directory  string // imports are relative to this directory
+	namespace  string // prefix for the names of functions and types declared in an imported file
+	imports    *Imports
+	namespaces map[string]struct{}
+
+	blockDepth    int
 	loopBodyCount int
 	forCounter    int
+	matchCounter  int
 
 	parsingFunctionDeclaration bool
 	declaredFunctions          map[string]int
 	forwardCalls               []ForwardCall
-	declaredTypes              map[string]struct{}
+	forwardReferences          []ForwardReference
+	declaredTypes              map[string]*TypeStatement
+	methods                    []MethodDeclaration
+	variables                  map[string]struct{} // assigned variables and parameters of the current function
 }
 
 func (p *Parser) consume(i int) {
@@ -56,6 +108,7 @@ func (p *Parser) eof() bool {
 }
 
 func (p *Parser) parse() (Statement, error) {
+	p.variables = make(map[string]struct{})
 	statements := make([]Statement, 0)
 	for !p.eof() {
 		stmt, err := p.parseStatement()
@@ -71,14 +124,70 @@ func (p *Parser) parse() (Statement, error) {
 		tok := call.Token
 		functionName := call.Token.Lexeme
 		arity, found := p.declaredFunctions[functionName]
-		if !found {
+		if found && call.Call.Builtin {
+			// Functions declared in the script take precedence over builtins with the same name
+			call.Call.Builtin = false
+			call.Call.FunctionName = p.namespace + functionName
+		} else if call.Call.Builtin {
+			if err := checkNoArgumentNames(call.ArgumentNames); err != nil {
+				return nil, err
+			}
+			if arity := builtins[functionName].Arity; call.ArgumentCount != arity && arity != ArityVariadic {
+				return nil, fmt.Errorf("expected %d arguments but got %d for function '%s' at %d:%d", arity, call.ArgumentCount, functionName, tok.Line, tok.Col)
+			}
+			continue
+		}
+		if _, isVariable := call.Variables[functionName]; !found && isVariable {
+			// Not a function, but a variable that can contain a function
+			if err := checkNoArgumentNames(call.ArgumentNames); err != nil {
+				return nil, err
+			}
+			call.Call.Variable = true
+			call.Call.FunctionName = functionName
+			continue
+		} else if !found {
 			return nil, fmt.Errorf("no such function '%s' at %d:%d", functionName, tok.Line, tok.Col)
 		}
+		if typeStmt, found := p.declaredTypes[functionName]; found {
+			call.Call.Constructor = true
+			if err := resolveConstructorArguments(call.Call, call.ArgumentNames, typeStmt); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if err := checkNoArgumentNames(call.ArgumentNames); err != nil {
+			return nil, err
+		}
 		if call.ArgumentCount != arity {
 			return nil, fmt.Errorf("expected %d arguments but got %d for function '%s' at %d:%d", arity, call.ArgumentCount, functionName, tok.Line, tok.Col)
 		}
 	}
 
+	for _, method := range p.methods {
+		typeName, methodName := method.Type.Lexeme, method.Method.Lexeme
+		typeStmt, found := p.declaredTypes[typeName]
+		if !found {
+			tok := method.Type
+			return nil, fmt.Errorf("no such type '%s' for method '%s' at %d:%d", typeName, methodName, tok.Line, tok.Col)
+		} else if _, found := typeStmt.FieldMap[methodName]; found {
+			tok := method.Method
+			return nil, fmt.Errorf("method '%s' has the same name as a field of type '%s' at %d:%d", methodName, typeName, tok.Line, tok.Col)
+		}
+	}
+
+	for _, reference := range p.forwardReferences {
+		tok := reference.Token
+		functionName := tok.Lexeme
+		if _, found := p.declaredTypes[functionName]; found {
+			return nil, fmt.Errorf("cannot reference type '%s' as a function at %d:%d", functionName, tok.Line, tok.Col)
+		} else if _, found := p.declaredFunctions[functionName]; found {
+			reference.Reference.Builtin = false
+			reference.Reference.FunctionName = p.namespace + functionName
+		} else if !reference.Reference.Builtin {
+			return nil, fmt.Errorf("no such function '%s' at %d:%d", functionName, tok.Line, tok.Col)
+		}
+	}
+
 	return &BlockStatement{Statements: statements}, nil
 }
 
@@ -89,7 +198,12 @@ func (p *Parser) parseStatement() (stmt Statement, err error) {
 		return nil, nil
 	}
 
-	if p.current().Type == TokenIf {
+	if p.current().Type == TokenImport {
+		stmt, err = p.parseImportStatement()
+		if err != nil {
+			return nil, err
+		}
+	} else if p.current().Type == TokenIf {
 		stmt, err = p.parseIfStatement()
 		if err != nil {
 			return nil, err
@@ -104,6 +218,16 @@ func (p *Parser) parseStatement() (stmt Statement, err error) {
 		if err != nil {
 			return nil, err
 		}
+	} else if p.current().Type == TokenAttempt {
+		stmt, err = p.parseAttemptStatement()
+		if err != nil {
+			return nil, err
+		}
+	} else if p.isMatchStatement() {
+		stmt, err = p.parseMatchStatement()
+		if err != nil {
+			return nil, err
+		}
 	} else if p.current().Type == TokenExit {
 		stmt, err = p.parseExitStatement()
 		if err != nil {
@@ -119,6 +243,12 @@ func (p *Parser) parseStatement() (stmt Statement, err error) {
 		if err != nil {
 			return nil, err
 		}
+	} else if p.left() >= 4 && p.current().Type == TokenIdentifier && p.next().Type == TokenFullStop &&
+		p.nextN(2).Type == TokenIdentifier && p.nextN(3).Type == TokenPipe {
+		stmt, err = p.parseMethodDeclarationStatement()
+		if err != nil {
+			return nil, err
+		}
 	} else if p.hasNext() && p.current().Type == TokenIdentifier && p.next().Type == TokenPipe {
 		stmt, err = p.parseFunctionDeclarationStatement()
 		if err != nil {
@@ -152,6 +282,7 @@ func (p *Parser) parseBlock(typ string) (Statement, error) {
 	token := p.current()
 
 	p.consume(1)
+	p.blockDepth += 1
 	statements := make([]Statement, 0)
 	for p.hasCurrent() && p.current().Type != TokenBraceClose {
 		stmt, err := p.parseStatement()
@@ -169,9 +300,129 @@ func (p *Parser) parseBlock(typ string) (Statement, error) {
 	}
 
 	p.consume(1)
+	p.blockDepth -= 1
 	return &BlockStatement{Token: token, Statements: statements}, nil
 }
 
+func (p *Parser) parseImportStatement() (Statement, error) {
+	// import "file.toi" or import "file.toi" as namespace
+	token := p.current()
+
+	if p.blockDepth != 0 {
+		return nil, fmt.Errorf("can only use 'import' at the top level at %d:%d", token.Line, token.Col)
+	}
+
+	if !p.hasNext() || p.next().Type != TokenString {
+		tok := p.next()
+		return nil, fmt.Errorf("expected file name after 'import' but got '%v' at %d:%d", tok.Type, tok.Line, tok.Col)
+	}
+	pathToken := p.next()
+	p.consume(2)
+
+	namespace, prefix := "", ""
+	if p.hasCurrent() && p.current().Type == TokenAs {
+		if !p.hasNext() || p.next().Type != TokenIdentifier {
+			tok := p.next()
+			return nil, fmt.Errorf("expected namespace identifier after 'as' but got '%v' at %d:%d", tok.Type, tok.Line, tok.Col)
+		}
+		namespace = p.next().Lexeme
+		prefix = namespace + "."
+		p.consume(2)
+	}
+
+	path := pathToken.Literal.(string)
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(p.directory, path)
+	}
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return nil, fmt.Errorf("invalid import path '%s' at %d:%d: %w", pathToken.Literal, token.Line, token.Col, err)
+	}
+
+	for i, parsing := range p.imports.parsing {
+		if parsing == path {
+			cycle := append(append([]string{}, p.imports.parsing[i:]...), path)
+			return nil, fmt.Errorf("import cycle (%s) at %d:%d", strings.Join(cycle, " -> "), token.Line, token.Col)
+		}
+	}
+
+	key := path + ":" + p.namespace + prefix
+	module, found := p.imports.modules[key]
+	statements := make([]Statement, 0)
+	if !found {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read imported file at %d:%d: %w", token.Line, token.Col, err)
+		}
+
+		tokens, errs := tokenize(string(data))
+		if len(errs) != 0 {
+			return nil, fmt.Errorf("tokenization error in imported file '%s': %w", path, errors.Join(errs...))
+		}
+
+		importParser := &Parser{
+			tokens:            tokens,
+			directory:         filepath.Dir(path),
+			namespace:         p.namespace + prefix,
+			imports:           p.imports,
+			namespaces:        make(map[string]struct{}),
+			declaredFunctions: make(map[string]int),
+			declaredTypes:     make(map[string]*TypeStatement),
+		}
+
+		p.imports.parsing = append(p.imports.parsing, path)
+		block, err := importParser.parse()
+		if err != nil {
+			return nil, fmt.Errorf("in imported file '%s': %w", path, err)
+		}
+		p.imports.parsing = p.imports.parsing[:len(p.imports.parsing)-1]
+
+		for _, stmt := range block.(*BlockStatement).Statements {
+			switch stmt.(type) {
+			case *FunctionDeclarationStatement, *TypeStatement, *ImportStatement:
+				statements = append(statements, stmt)
+			default:
+				lineCol := stmt.lineCol()
+				return nil, fmt.Errorf("imported file '%s' may only contain functions, types and imports, but found %v at %d:%d", path, reflect.TypeOf(stmt), lineCol.line, lineCol.col)
+			}
+		}
+
+		module = &ImportedModule{
+			functions:  importParser.declaredFunctions,
+			types:      importParser.declaredTypes,
+			namespaces: importParser.namespaces,
+		}
+		p.imports.modules[key] = module
+	}
+
+	// Names are unique across all files (see Parser.declare), so we don't have to check for duplicates here
+	for name, arity := range module.functions {
+		p.declaredFunctions[prefix+name] = arity
+	}
+	for name, typeStmt := range module.types {
+		p.declaredTypes[prefix+name] = typeStmt
+	}
+	for name := range module.namespaces {
+		p.namespaces[prefix+name] = struct{}{}
+	}
+	if namespace != "" {
+		p.namespaces[namespace] = struct{}{}
+	}
+
+	return &ImportStatement{Token: token, Path: path, Namespace: namespace, Statements: statements}, nil
+}
+
+// declare registers the fully qualified name of a function or type, and returns that name
+func (p *Parser) declare(identifierToken Token) (string, error) {
+	qualified := p.namespace + identifierToken.Lexeme
+	if _, found := p.imports.declared[qualified]; found {
+		tok := identifierToken
+		return "", fmt.Errorf("'%v' is already declared in another file at %d:%d", qualified, tok.Line, tok.Col)
+	}
+	p.imports.declared[qualified] = struct{}{}
+	return qualified, nil
+}
+
 func (p *Parser) parseTypeStatement() (Statement, error) {
 	identifierToken := p.current()
 	startToken := identifierToken
@@ -190,14 +441,32 @@ func (p *Parser) parseTypeStatement() (Statement, error) {
 
 	fields := make([]Token, 0)
 	fieldMap := make(map[string]int)
+	defaults := make([]Expression, 0)
 	for p.hasCurrent() && p.current().Type == TokenIdentifier {
-		if _, found := fieldMap[p.current().Lexeme]; found {
-			tok := p.current()
+		fieldToken := p.current()
+		if _, found := fieldMap[fieldToken.Lexeme]; found {
+			tok := fieldToken
 			return nil, fmt.Errorf("duplicate field name '%v' in type declaration '%v' at %d:%d", tok.Lexeme, identifier, tok.Line, tok.Col)
 		}
-		fieldMap[p.current().Lexeme] = len(fields)
-		fields = append(fields, p.current())
+		fieldMap[fieldToken.Lexeme] = len(fields)
+		fields = append(fields, fieldToken)
 		p.consume(1)
+
+		// field = defaultValue
+		var defaultValue Expression
+		if p.hasCurrent() && p.current().Type == TokenEquals {
+			p.consume(1)
+			var err error
+			defaultValue, err = p.parseExpression()
+			if err != nil {
+				return nil, err
+			}
+			if !isConstantExpression(defaultValue) {
+				tok := fieldToken
+				return nil, fmt.Errorf("default value of field '%v' in type declaration '%v' must be a constant expression at %d:%d", tok.Lexeme, identifier, tok.Line, tok.Col)
+			}
+		}
+		defaults = append(defaults, defaultValue)
 	}
 
 	if len(fields) == 0 {
@@ -215,15 +484,103 @@ func (p *Parser) parseTypeStatement() (Statement, error) {
 	}
 	p.consume(1)
 
-	p.declaredTypes[identifier] = struct{}{}
-	p.declaredFunctions[identifier] = len(fields)
+	qualified, err := p.declare(identifierToken)
+	if err != nil {
+		return nil, err
+	}
+	identifierToken.Lexeme = qualified
 
-	return &TypeStatement{
+	typeStmt := &TypeStatement{
 		Token:      startToken,
 		Identifier: identifierToken,
 		Fields:     fields,
 		FieldMap:   fieldMap,
-	}, nil
+		Defaults:   defaults,
+	}
+	p.declaredTypes[identifier] = typeStmt
+	p.declaredFunctions[identifier] = len(fields)
+	return typeStmt, nil
+}
+
+// isConstantExpression returns whether the expression does not depend on any variables or declared functions, so
+// it can be used as the default value of a field
+func isConstantExpression(e Expression) bool {
+	switch expr := e.(type) {
+	case *LiteralExpression:
+		return true
+	case *BinaryExpression:
+		return isConstantExpression(expr.Left) && isConstantExpression(expr.Right)
+	case *UnaryExpression:
+		return isConstantExpression(expr.Operand)
+	case *ConditionalExpression:
+		return isConstantExpression(expr.Condition) && isConstantExpression(expr.Then) && isConstantExpression(expr.Otherwise)
+	case *FunctionCallExpression:
+		return expr.Builtin && !slices.ContainsFunc(expr.Arguments, func(e Expression) bool { return !isConstantExpression(e) })
+	}
+	return false
+}
+
+// resolveConstructorArguments finds the field of each (possibly named) argument of a constructor call, and adds the
+// default values of fields without an argument
+func resolveConstructorArguments(call *FunctionCallExpression, names []Token, typeStmt *TypeStatement) error {
+	tok := call.Token
+	typeName := tok.Lexeme
+	if len(call.Arguments) > len(typeStmt.Fields) {
+		return fmt.Errorf("expected at most %d arguments but got %d for type '%s' at %d:%d", len(typeStmt.Fields), len(call.Arguments), typeName, tok.Line, tok.Col)
+	}
+
+	// Arguments are evaluated in the order they are written, and then assigned to their fields
+	fieldIndexes := make([]int, 0, len(typeStmt.Fields))
+	given := make([]bool, len(typeStmt.Fields))
+	named := false
+	for i, argument := range call.Arguments {
+		index := i
+		if names[i].Type == TokenIdentifier {
+			name := names[i]
+			named = true
+			var found bool
+			index, found = typeStmt.FieldMap[name.Lexeme]
+			if !found {
+				return fmt.Errorf("type '%s' has no field '%s' at %d:%d", typeName, name.Lexeme, name.Line, name.Col)
+			} else if given[index] {
+				return fmt.Errorf("field '%s' given more than once for type '%s' at %d:%d", name.Lexeme, typeName, name.Line, name.Col)
+			}
+		} else if named {
+			lineCol := argument.lineCol()
+			return fmt.Errorf("positional arguments cannot follow named arguments for type '%s' at %d:%d", typeName, lineCol.line, lineCol.col)
+		}
+		given[index] = true
+		fieldIndexes = append(fieldIndexes, index)
+	}
+
+	arguments := call.Arguments
+	for i, isGiven := range given {
+		if isGiven {
+			continue
+		} else if typeStmt.Defaults[i] == nil {
+			return fmt.Errorf("missing value for field '%s' of type '%s' at %d:%d", typeStmt.Fields[i].Lexeme, typeName, tok.Line, tok.Col)
+		}
+		arguments = append(arguments, typeStmt.Defaults[i])
+		fieldIndexes = append(fieldIndexes, i)
+	}
+
+	call.Arguments = arguments
+	for i, index := range fieldIndexes {
+		if i != index {
+			call.FieldIndexes = fieldIndexes
+			break
+		}
+	}
+	return nil
+}
+
+func checkNoArgumentNames(names []Token) error {
+	for _, name := range names {
+		if name.Type == TokenIdentifier {
+			return fmt.Errorf("named arguments can only be used to construct custom types at %d:%d", name.Line, name.Col)
+		}
+	}
+	return nil
 }
 
 func (p *Parser) parseIfStatement() (Statement, error) {
@@ -273,7 +630,7 @@ func (p *Parser) parseWhileStatement() (Statement, error) {
 }
 
 func (p *Parser) parseForStatement() (Statement, error) {
-	// for value = [arrayOrMap]indexOrKey { ... }
+	// for value = [arrayOrMap]indexOrKey { ... } or for i = from..to step s { ... }
 	token := p.current()
 
 	if p.left() < 4 {
@@ -288,8 +645,10 @@ func (p *Parser) parseForStatement() (Statement, error) {
 		tok := p.next()
 		return nil, fmt.Errorf("expected '=' after 'for' identifier but got '%v' at %d:%d", tok.Type, tok.Line, tok.Col)
 	} else if p.nextN(2).Type != TokenBracketOpen {
-		tok := p.nextN(2)
-		return nil, fmt.Errorf("expected '[' after '=' in 'for' but got '%v' at %d:%d", tok.Type, tok.Line, tok.Col)
+		variableIdentifier := p.current()
+		p.declareVariable(variableIdentifier)
+		p.consume(2) // identifier and equals
+		return p.parseRangeForStatement(token, variableIdentifier)
 	}
 
 	valueIdentifier := p.current()
@@ -300,15 +659,24 @@ func (p *Parser) parseForStatement() (Statement, error) {
 		return nil, err
 	}
 
-	if p.left() < 2 || p.current().Type != TokenBracketClose || p.next().Type != TokenIdentifier {
+	if p.left() < 2 || p.current().Type != TokenBracketClose || (p.next().Type != TokenIdentifier && p.next().Type != TokenUnderscore) {
 		tok := p.current()
 		return nil, fmt.Errorf("expected ']' and index identifier 'for' container expression but got '%v' at %d:%d", tok.Type, tok.Line, tok.Col)
 	}
 
 	keyIdentifier := p.next()
+	p.declareVariable(valueIdentifier)
+	p.declareVariable(keyIdentifier)
 
 	p.consume(2)
 
+	p.forCounter += 1
+	f := strconv.Itoa(p.forCounter)
+	if keyIdentifier.Type == TokenUnderscore {
+		// for value = [container]_ { ... } when the index or key is not needed
+		keyIdentifier = Token{Type: TokenIdentifier, Lexeme: "_for_key_" + f, Line: keyIdentifier.Line, Col: keyIdentifier.Col}
+	}
+
 	p.loopBodyCount += 1
 	block, err := p.parseBlock("for expression")
 	if err != nil {
@@ -316,79 +684,101 @@ func (p *Parser) parseForStatement() (Statement, error) {
 	}
 	p.loopBodyCount -= 1
 
-	ident := func(s string) Token { return Token{Type: TokenIdentifier, Lexeme: s} }
+	iteratorIdent := Token{Type: TokenIdentifier, Lexeme: "_for_iterator_" + f}
+
+	return &BlockStatement{
+		Token: token,
+		Statements: []Statement{
+			&AssignmentStatement{ // _for_iterator = iterator over (container expression)
+				Identifier: iteratorIdent,
+				Expression: &IteratorExpression{Token: token, Container: containerExpression},
+			},
+			&WhileStatement{
+				Token: token,
+				Condition: &IteratorNextExpression{ // sets (user defined) key and value, until there are no more
+					Token:    token,
+					Iterator: iteratorIdent,
+					Key:      keyIdentifier,
+					Value:    valueIdentifier,
+				},
+				Body: block,
+			},
+		},
+	}, nil
+}
+
+func (p *Parser) parseRangeForStatement(token Token, variableIdentifier Token) (Statement, error) {
+	// for i = from..to step s { ... }; the end is exclusive, and `step` is only a keyword here, so it can still be used
+	// as a variable name elsewhere
+	fromExpression, err := p.parseExpression()
+	if err != nil {
+		return nil, err
+	}
+
+	if p.eof() {
+		return nil, fmt.Errorf("incomplete 'for' statement at %d:%d", token.Line, token.Col)
+	} else if p.current().Type != TokenDotDot {
+		tok := p.current()
+		return nil, fmt.Errorf("expected '[' or '..' in 'for' but got '%v' at %d:%d", tok.Type, tok.Line, tok.Col)
+	}
+	p.consume(1)
+
+	toExpression, err := p.parseExpression()
+	if err != nil {
+		return nil, err
+	}
+
+	var stepExpression Expression = &LiteralExpression{Token{Type: TokenNumber, Lexeme: "1", Literal: 1}}
+	if !p.eof() && p.current().Type == TokenIdentifier && p.current().Lexeme == "step" {
+		p.consume(1)
+		if stepExpression, err = p.parseExpression(); err != nil {
+			return nil, err
+		}
+	}
 
 	p.forCounter += 1
 	f := strconv.Itoa(p.forCounter)
-	containerIdent := ident("_for_container_" + f)
-	containerExpr := &VariableExpression{containerIdent}
-	keysIdent := ident("_for_keys_" + f)
-	keysExpr := &VariableExpression{keysIdent}
-	indexIdent := ident("_for_index_" + f)
-	indexExpr := &VariableExpression{indexIdent}
+
+	p.loopBodyCount += 1
+	block, err := p.parseBlock("for expression")
+	if err != nil {
+		return nil, err
+	}
+	p.loopBodyCount -= 1
+
+	endIdent := Token{Type: TokenIdentifier, Lexeme: "_for_end_" + f}
+	stepIdent := Token{Type: TokenIdentifier, Lexeme: "_for_step_" + f}
 
 	return &BlockStatement{
 		Token: token,
 		Statements: []Statement{
-			&AssignmentStatement{ // _for_container = (container expression)
-				Identifier: containerIdent,
-				Expression: containerExpression,
+			&AssignmentStatement{ // (user defined) i = (from expression)
+				Identifier: variableIdentifier,
+				Expression: fromExpression,
 			},
-			&AssignmentStatement{ // _for_keys = keys(_for_container)
-				Identifier: keysIdent,
-				Expression: &FunctionCallExpression{
-					Token:        keysIdent,
-					Builtin:      true,
-					FunctionName: "keys",
-					Arguments:    []Expression{containerExpr},
-				},
+			&AssignmentStatement{ // _for_end = (to expression)
+				Identifier: endIdent,
+				Expression: toExpression,
+			},
+			&AssignmentStatement{ // _for_step = (step expression)
+				Identifier: stepIdent,
+				Expression: stepExpression,
 			},
-			&AssignmentStatement{ // _for_index = 0
-				Identifier: indexIdent,
-				Expression: &LiteralExpression{Token{Type: TokenNumber, Lexeme: "0", Literal: 0}},
-			}, // _i
 			&WhileStatement{
 				Token: token,
-				Condition: &BinaryExpression{ // _for_index < len(_for_keys)
-					Left:     indexExpr,
-					Operator: Token{Type: TokenLessThan, Lexeme: "<"},
-					Right: &FunctionCallExpression{
-						Token:        Token{Type: TokenIdentifier, Lexeme: "len"},
-						Builtin:      true,
-						FunctionName: "len",
-						Arguments:    []Expression{keysExpr},
-					},
+				Condition: &RangeConditionExpression{ // i < _for_end, or i > _for_end when counting down
+					Token:    token,
+					Variable: variableIdentifier,
+					End:      endIdent,
+					Step:     stepIdent,
 				},
-				Body: &BlockStatement{
-					Token: token,
-					Statements: []Statement{
-						&AssignmentStatement{ // (used defined) key = [_for_keys]_for_index
-							Identifier: keyIdentifier,
-							Expression: &FunctionCallExpression{ // get(_for_keys, _for_index)
-								Token:        Token{Type: TokenIdentifier, Lexeme: "get"},
-								Builtin:      true,
-								FunctionName: "get",
-								Arguments:    []Expression{keysExpr, indexExpr},
-							},
-						},
-						&AssignmentStatement{ // (user defined) value = [container]key
-							Identifier: valueIdentifier,
-							Expression: &FunctionCallExpression{ // get(container, key)
-								Token:        Token{Type: TokenIdentifier, Lexeme: "get"},
-								Builtin:      true,
-								FunctionName: "get",
-								Arguments:    []Expression{containerExpr, &VariableExpression{keyIdentifier}},
-							},
-						},
-						block,
-					},
-				},
-				AfterBody: &AssignmentStatement{ // _for_index = _for_index + 1
-					Identifier: indexIdent,
+				Body: block,
+				AfterBody: &AssignmentStatement{ // i = i + _for_step
+					Identifier: variableIdentifier,
 					Expression: &BinaryExpression{
-						Left:     indexExpr,
+						Left:     &VariableExpression{variableIdentifier},
 						Operator: Token{Type: TokenPlus, Lexeme: "+"},
-						Right:    &LiteralExpression{Token{Type: TokenNumber, Lexeme: "1", Literal: 1}},
+						Right:    &VariableExpression{stepIdent},
 					},
 				},
 			},
@@ -396,121 +786,501 @@ func (p *Parser) parseForStatement() (Statement, error) {
 	}, nil
 }
 
-func (p *Parser) parseExitStatement() (Statement, error) {
+func (p *Parser) parseAttemptStatement() (Statement, error) {
+	// attempt { ... } failure err { ... }
 	token := p.current()
+	p.consume(1)
 
-	if !p.hasNext() || (p.next().Type != TokenLoop && p.next().Type != TokenFunction) {
-		tok := p.next()
-		return nil, fmt.Errorf("expected 'loop' after 'exit' at %d:%d", tok.Line, tok.Col)
+	body, err := p.parseBlock("'attempt'")
+	if err != nil {
+		return nil, err
 	}
 
-	if p.next().Type == TokenLoop {
-		if p.loopBodyCount == 0 {
-			tok := token
-			return nil, fmt.Errorf("can only use 'exit loop' in 'while' or 'for' body at %d:%d", tok.Line, tok.Col)
-		}
-
-		p.consume(2)
-		return &ExitLoopStatement{Token: token}, nil
-	} else {
-		if !p.parsingFunctionDeclaration {
-			tok := token
-			return nil, fmt.Errorf("can only use 'exit function' inside a function at %d:%d", tok.Line, tok.Col)
+	if !p.hasCurrent() || p.current().Type != TokenFailure {
+		tok := token
+		if p.hasCurrent() {
+			tok = p.current()
 		}
-
-		p.consume(2)
-		return &ExitFunctionStatement{Token: token}, nil
+		return nil, fmt.Errorf("expected 'failure' after 'attempt' block at %d:%d", tok.Line, tok.Col)
 	}
-}
-
-func (p *Parser) parseNextIterationStatement() (Statement, error) {
-	token := p.current()
+	p.consume(1)
 
-	if !p.hasNext() || p.next().Type != TokenIteration {
-		tok := p.next()
-		return nil, fmt.Errorf("expected 'iteration' after 'next' at %d:%d", tok.Line, tok.Col)
+	var errorVariable *Token
+	if p.hasCurrent() && p.current().Type == TokenIdentifier {
+		tok := p.current()
+		errorVariable = &tok
+		p.declareVariable(tok)
+		p.consume(1)
 	}
 
-	if p.loopBodyCount == 0 {
-		tok := token
-		return nil, fmt.Errorf("can only use 'next iteration' in 'while' or 'for' body at %d:%d", tok.Line, tok.Col)
+	failure, err := p.parseBlock("'failure'")
+	if err != nil {
+		return nil, err
 	}
-
-	p.consume(2)
-	return &NextIterationStatement{Token: token}, nil
+	return &AttemptStatement{Token: token, Body: body, ErrorVariable: errorVariable, Failure: failure}, nil
 }
 
-func (p *Parser) parseFunctionDeclarationStatement() (Statement, error) {
-	startToken := p.current()
-	identifier := startToken.Lexeme
-	p.consume(2) // identifier and |
-
-	if p.parsingFunctionDeclaration {
-		tok := startToken
-		return nil, fmt.Errorf("function declarations cannot appear inside other functions at %d:%d", tok.Line, tok.Col)
+// isMatchStatement returns whether the current token starts a match statement; 'match' is not a keyword, so it can
+// still be a variable (match = ...) or a call of the match() builtin
+func (p *Parser) isMatchStatement() bool {
+	if p.current().Type != TokenIdentifier || p.current().Lexeme != "match" || !p.hasNext() || !startsOperand(p.next().Type) {
+		return false
+	} else if p.next().Type != TokenParenOpen {
+		return true
 	}
 
-	parameters := make([]Token, 0)
-	paramMap := make(map[string]struct{})
-	for p.hasCurrent() && p.current().Type == TokenIdentifier {
-		if _, found := paramMap[p.current().Lexeme]; found {
-			tok := p.current()
-			return nil, fmt.Errorf("duplicate parameter name '%v' in function declaration '%v' at %d:%d", tok.Lexeme, identifier, tok.Line, tok.Col)
+	// match(...) on its own is a call; it is a match statement when more follows, as in match (a + b) { ... }
+	depth := 0
+	for i := 1; i < p.left(); i++ {
+		switch p.nextN(i).Type {
+		case TokenParenOpen:
+			depth += 1
+		case TokenParenClose:
+			depth -= 1
+			if depth == 0 {
+				return i+1 < p.left() && p.nextN(i+1).Type != TokenNewline && p.nextN(i+1).Type != TokenBraceClose
+			}
 		}
-		paramMap[p.current().Lexeme] = struct{}{}
-		parameters = append(parameters, p.current())
-		p.consume(1)
 	}
+	return false
+}
 
-	if !p.hasCurrent() || p.current().Type != TokenPipe {
-		tok := p.current()
-		return nil, fmt.Errorf("expected '|' after function parameters but got '%v' at %d:%d", tok.Type, tok.Line, tok.Col)
-	}
+func (p *Parser) parseMatchStatement() (Statement, error) {
+	// match expression { pattern { ... } pattern, pattern { ... } otherwise { ... } }
+	token := p.current()
 	p.consume(1)
 
-	_, found := builtins[identifier]
-	if found {
-		tok := startToken
-		return nil, fmt.Errorf("cannot use '%v' as function name, it is a builtin function at %d:%d", identifier, tok.Line, tok.Col)
-	}
-
-	_, found = p.declaredFunctions[identifier]
-	if found {
-		tok := startToken
-		return nil, fmt.Errorf("function '%v' re-declared %d:%d", identifier, tok.Line, tok.Col)
+	expression, err := p.parseExpression()
+	if err != nil {
+		return nil, err
 	}
-
-	arity := len(parameters)
-	if arity > 50 {
-		tok := startToken
-		return nil, fmt.Errorf("functions don't support more than 50 arguments (was %d for '%v') at %d:%d", arity, tok.Lexeme, tok.Line, tok.Col)
+	if !p.hasCurrent() || p.current().Type != TokenBraceOpen {
+		tok := p.current()
+		return nil, fmt.Errorf("expected '{' after 'match' expression but got '%v' at %d:%d", tok.Type, tok.Line, tok.Col)
 	}
+	p.consume(1)
 
-	var outVariable *Token
-	if p.hasCurrent() && p.current().Type == TokenIdentifier {
+	p.matchCounter += 1
+	subject := Token{Type: TokenIdentifier, Lexeme: "_match_subject_" + strconv.Itoa(p.matchCounter)}
+	statement := &MatchStatement{Token: token, Subject: subject, Expression: expression}
+	for {
+		for p.hasCurrent() && p.current().Type == TokenNewline {
+			p.consume(1)
+		}
+		if !p.hasCurrent() {
+			return nil, fmt.Errorf("expected '}' after 'match' arms at %d:%d", token.Line, token.Col)
+		} else if p.current().Type == TokenBraceClose {
+			p.consume(1)
+			return statement, nil
+		} else if statement.Otherwise != nil {
+			tok := p.current()
+			return nil, fmt.Errorf("'otherwise' must be the last arm of 'match' at %d:%d", tok.Line, tok.Col)
+		}
+
+		if p.current().Type == TokenOtherwise {
+			p.consume(1)
+			if statement.Otherwise, err = p.parseBlock("otherwise"); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		var patterns []Pattern
+		for {
+			pattern, err := p.parsePattern(true)
+			if err != nil {
+				return nil, err
+			}
+			patterns = append(patterns, pattern)
+			if !p.hasCurrent() || p.current().Type != TokenComma {
+				break
+			}
+			p.consume(1)
+		}
+
+		body, err := p.parseBlock("'match' pattern")
+		if err != nil {
+			return nil, err
+		}
+		statement.Arms = append(statement.Arms, MatchArm{Patterns: patterns, Body: body})
+	}
+}
+
+// parsePattern parses a pattern of a match arm; an identifier at the top level is a type name, while within an
+// array pattern it is a variable that the element is assigned to (and a type needs braces, like Point{} or Point{x y})
+func (p *Parser) parsePattern(topLevel bool) (Pattern, error) {
+	if !p.hasCurrent() {
+		return nil, fmt.Errorf("expected pattern but got end of file")
+	}
+
+	token := p.current()
+	switch token.Type {
+	case TokenNumber, TokenString, TokenNil:
+		p.consume(1)
+		return &LiteralPattern{Literal: &LiteralExpression{Token: token}}, nil
+	case TokenMinus:
+		if p.hasNext() && p.next().Type == TokenNumber {
+			// Negative number literal
+			expression, err := p.parseUnary()
+			if err != nil {
+				return nil, err
+			}
+			if literal, ok := expression.(*LiteralExpression); ok {
+				return &LiteralPattern{Literal: literal}, nil
+			}
+			return nil, fmt.Errorf("expected pattern but got expression at %d:%d", token.Line, token.Col)
+		}
+	case TokenUnderscore:
+		p.consume(1)
+		return &WildcardPattern{Token: token}, nil
+	case TokenBracketOpen:
+		return p.parseArrayPattern()
+	case TokenIdentifier:
+		if !topLevel && (!p.hasNext() || p.next().Type != TokenBraceOpen) {
+			p.consume(1)
+			p.declareVariable(token)
+			return &BindingPattern{Identifier: token}, nil
+		}
+		return p.parseTypePattern(topLevel)
+	}
+	return nil, fmt.Errorf("expected pattern but got %s ('%s') at %d:%d", token.Type, token.Lexeme, token.Line, token.Col)
+}
+
+func (p *Parser) parseTypePattern(topLevel bool) (Pattern, error) {
+	// Type, Type{}, or Type{field1 field2}
+	token := p.current()
+	name, length := p.namespacedName()
+	_, builtinType := builtinTypeNames[name]
+	if !builtinType {
+		name = p.namespace + name
+	}
+	p.consume(length)
+
+	pattern := &TypePattern{Token: token, TypeName: name}
+	if !p.hasCurrent() || p.current().Type != TokenBraceOpen || (topLevel && !p.isFieldList()) {
+		return pattern, nil
+	}
+
+	p.consume(1)
+	for p.hasCurrent() && p.current().Type == TokenIdentifier {
+		pattern.Fields = append(pattern.Fields, p.current())
+		p.declareVariable(p.current())
+		p.consume(1)
+	}
+	if !p.hasCurrent() || p.current().Type != TokenBraceClose {
 		tok := p.current()
-		if _, found := paramMap[tok.Lexeme]; found {
-			return nil, fmt.Errorf("duplicate parameter name '%v' in function declaration '%v' at %d:%d", tok.Lexeme, identifier, tok.Line, tok.Col)
+		return nil, fmt.Errorf("expected field name or '}' in type pattern but got '%v' at %d:%d", tok.Type, tok.Line, tok.Col)
+	}
+	p.consume(1)
+
+	if builtinType && len(pattern.Fields) != 0 {
+		return nil, fmt.Errorf("type '%s' has no fields to bind at %d:%d", name, token.Line, token.Col)
+	}
+	return pattern, nil
+}
+
+// isFieldList returns whether the current '{' starts the fields of a type pattern (Type{x y} { ... }) rather than the
+// body of the arm (Type { ... })
+func (p *Parser) isFieldList() bool {
+	i := 1
+	for i < p.left() && p.nextN(i).Type == TokenIdentifier {
+		i += 1
+	}
+	return i+1 < p.left() && p.nextN(i).Type == TokenBraceClose && p.nextN(i+1).Type == TokenBraceOpen
+}
+
+func (p *Parser) parseArrayPattern() (Pattern, error) {
+	// [pattern, pattern, ..rest]
+	token := p.current()
+	p.consume(1)
+
+	pattern := &ArrayPattern{Token: token}
+	for p.hasCurrent() && p.current().Type != TokenBracketClose {
+		if p.current().Type == TokenDotDot {
+			// ..rest or .._ for the remaining elements, or just .. when they are not needed
+			pattern.HasRest = true
+			p.consume(1)
+			pattern.Rest = Token{Type: TokenUnderscore, Lexeme: "_"}
+			if p.hasCurrent() && (p.current().Type == TokenIdentifier || p.current().Type == TokenUnderscore) {
+				pattern.Rest = p.current()
+				p.declareVariable(p.current())
+				p.consume(1)
+			}
+			break
 		}
-		paramMap[tok.Lexeme] = struct{}{}
 
-		outVariable = &tok
+		element, err := p.parsePattern(false)
+		if err != nil {
+			return nil, err
+		}
+		pattern.Elements = append(pattern.Elements, element)
+		if !p.hasCurrent() || p.current().Type != TokenComma {
+			break
+		}
 		p.consume(1)
 	}
 
+	if !p.hasCurrent() || p.current().Type != TokenBracketClose {
+		tok := p.current()
+		return nil, fmt.Errorf("expected ']' after array pattern but got '%v' at %d:%d", tok.Type, tok.Line, tok.Col)
+	} else if len(pattern.Elements) > 0xFF {
+		return nil, fmt.Errorf("array pattern cannot have more than %d elements at %d:%d", 0xFF, token.Line, token.Col)
+	}
+	p.consume(1)
+	return pattern, nil
+}
+
+func (p *Parser) parseExitStatement() (Statement, error) {
+	token := p.current()
+
+	if !p.hasNext() || (p.next().Type != TokenLoop && p.next().Type != TokenFunction) {
+		tok := p.next()
+		return nil, fmt.Errorf("expected 'loop' after 'exit' at %d:%d", tok.Line, tok.Col)
+	}
+
+	if p.next().Type == TokenLoop {
+		if p.loopBodyCount == 0 {
+			tok := token
+			return nil, fmt.Errorf("can only use 'exit loop' in 'while' or 'for' body at %d:%d", tok.Line, tok.Col)
+		}
+
+		p.consume(2)
+		return &ExitLoopStatement{Token: token}, nil
+	} else {
+		if !p.parsingFunctionDeclaration {
+			tok := token
+			return nil, fmt.Errorf("can only use 'exit function' inside a function at %d:%d", tok.Line, tok.Col)
+		}
+
+		p.consume(2)
+		return &ExitFunctionStatement{Token: token}, nil
+	}
+}
+
+func (p *Parser) parseNextIterationStatement() (Statement, error) {
+	token := p.current()
+
+	if !p.hasNext() || p.next().Type != TokenIteration {
+		tok := p.next()
+		return nil, fmt.Errorf("expected 'iteration' after 'next' at %d:%d", tok.Line, tok.Col)
+	}
+
+	if p.loopBodyCount == 0 {
+		tok := token
+		return nil, fmt.Errorf("can only use 'next iteration' in 'while' or 'for' body at %d:%d", tok.Line, tok.Col)
+	}
+
+	p.consume(2)
+	return &NextIterationStatement{Token: token}, nil
+}
+
+func (p *Parser) parseFunctionDeclarationStatement() (Statement, error) {
+	startToken := p.current()
+	identifier := startToken.Lexeme
+	p.consume(2) // identifier and |
+
+	if p.parsingFunctionDeclaration {
+		tok := startToken
+		return nil, fmt.Errorf("function declarations cannot appear inside other functions at %d:%d", tok.Line, tok.Col)
+	}
+
+	parameters, outVariables, err := p.parseFunctionSignature(identifier)
+	if err != nil {
+		return nil, err
+	}
+
+	_, found := p.declaredFunctions[identifier]
+	if found {
+		tok := startToken
+		return nil, fmt.Errorf("function '%v' re-declared %d:%d", identifier, tok.Line, tok.Col)
+	}
+
+	arity := len(parameters)
+	if arity > 50 {
+		tok := startToken
+		return nil, fmt.Errorf("functions don't support more than 50 arguments (was %d for '%v') at %d:%d", arity, tok.Lexeme, tok.Line, tok.Col)
+	}
+
+	qualified, err := p.declare(startToken)
+	if err != nil {
+		return nil, err
+	}
+
+	scriptVariables := p.variables
+
 	p.parsingFunctionDeclaration = true
 	p.declaredFunctions[startToken.Lexeme] = arity // we got recursion baby
+	p.variables = functionVariables(parameters, outVariables)
 	body, err := p.parseBlock("function parameters")
 	if err != nil {
 		return nil, err
 	}
 	p.parsingFunctionDeclaration = false
+	p.variables = scriptVariables
+
+	startToken.Lexeme = qualified
 
 	return &FunctionDeclarationStatement{
-		Identifier:  startToken,
-		Parameters:  parameters,
-		OutVariable: outVariable,
-		Body:        body,
+		Identifier:   startToken,
+		Parameters:   parameters,
+		OutVariables: outVariables,
+		Body:         body,
+	}, nil
+}
+
+func (p *Parser) parseMethodDeclarationStatement() (Statement, error) {
+	// Type.method|parameters| outVariables { ... }
+	typeToken := p.current()
+	methodToken := p.nextN(2)
+	p.consume(4) // type, '.', method, and '|'
+
+	if p.parsingFunctionDeclaration {
+		tok := typeToken
+		return nil, fmt.Errorf("method declarations cannot appear inside functions at %d:%d", tok.Line, tok.Col)
+	}
+
+	name := typeToken.Lexeme + "." + methodToken.Lexeme
+	parameters, outVariables, err := p.parseFunctionSignature(name)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, tok := range slices.Concat(parameters, outVariables) {
+		if tok.Lexeme == receiverName {
+			return nil, fmt.Errorf("cannot use '%v' as a parameter name in method '%v', it is the implicit receiver at %d:%d", receiverName, name, tok.Line, tok.Col)
+		}
+	}
+
+	for _, method := range p.methods {
+		if method.Type.Lexeme == typeToken.Lexeme && method.Method.Lexeme == methodToken.Lexeme {
+			tok := methodToken
+			return nil, fmt.Errorf("method '%v' re-declared %d:%d", name, tok.Line, tok.Col)
+		}
+	}
+	p.methods = append(p.methods, MethodDeclaration{Type: typeToken, Method: methodToken})
+
+	// The receiver is passed as the first argument
+	receiver := Token{Type: TokenIdentifier, Lexeme: receiverName, Line: typeToken.Line, Col: typeToken.Col}
+	parameters = append([]Token{receiver}, parameters...)
+	if len(parameters) > 50 {
+		tok := methodToken
+		return nil, fmt.Errorf("methods don't support more than 49 arguments (was %d for '%v') at %d:%d", len(parameters)-1, name, tok.Line, tok.Col)
+	}
+
+	identifier := typeToken
+	identifier.Lexeme = name
+	qualified, err := p.declare(identifier)
+	if err != nil {
+		return nil, err
+	}
+
+	p.parsingFunctionDeclaration = true
+	scriptVariables := p.variables
+	p.variables = functionVariables(parameters, outVariables)
+	body, err := p.parseBlock("method parameters")
+	if err != nil {
+		return nil, err
+	}
+	p.parsingFunctionDeclaration = false
+	p.variables = scriptVariables
+
+	identifier.Lexeme = qualified
+
+	return &FunctionDeclarationStatement{
+		Identifier:   identifier,
+		Parameters:   parameters,
+		OutVariables: outVariables,
+		Body:         body,
+	}, nil
+}
+
+// parseFunctionSignature parses the parameters, the closing '|', and the out-variables of a function
+func (p *Parser) parseFunctionSignature(identifier string) ([]Token, []Token, error) {
+	parameters := make([]Token, 0)
+	paramMap := make(map[string]struct{})
+	for p.hasCurrent() && p.current().Type == TokenIdentifier {
+		if _, found := paramMap[p.current().Lexeme]; found {
+			tok := p.current()
+			return nil, nil, fmt.Errorf("duplicate parameter name '%v' in function declaration '%v' at %d:%d", tok.Lexeme, identifier, tok.Line, tok.Col)
+		}
+		paramMap[p.current().Lexeme] = struct{}{}
+		parameters = append(parameters, p.current())
+		p.consume(1)
+	}
+
+	if !p.hasCurrent() || p.current().Type != TokenPipe {
+		tok := p.current()
+		return nil, nil, fmt.Errorf("expected '|' after function parameters but got '%v' at %d:%d", tok.Type, tok.Line, tok.Col)
+	}
+	p.consume(1)
+
+	outVariables := make([]Token, 0)
+	for p.hasCurrent() && p.current().Type == TokenIdentifier {
+		tok := p.current()
+		if _, found := paramMap[tok.Lexeme]; found {
+			return nil, nil, fmt.Errorf("duplicate parameter name '%v' in function declaration '%v' at %d:%d", tok.Lexeme, identifier, tok.Line, tok.Col)
+		}
+		paramMap[tok.Lexeme] = struct{}{}
+
+		outVariables = append(outVariables, tok)
+		p.consume(1)
+	}
+
+	return parameters, outVariables, nil
+}
+
+// functionVariables returns the variables a function starts with: its parameters and out-variables
+func functionVariables(parameters []Token, outVariables []Token) map[string]struct{} {
+	variables := make(map[string]struct{})
+	for _, tok := range slices.Concat(parameters, outVariables) {
+		variables[tok.Lexeme] = struct{}{}
+	}
+	return variables
+}
+
+// declareVariable records a variable that is assigned in the current function (or script), so that calling it calls
+// the function it contains, rather than being an error for calling an undeclared function
+func (p *Parser) declareVariable(identifier Token) {
+	p.variables[identifier.Lexeme] = struct{}{}
+}
+
+func (p *Parser) parseFunctionLiteral() (Expression, error) {
+	// |a b| c { ... }
+	token := p.current()
+	p.consume(1) // |
+
+	parameters, outVariables, err := p.parseFunctionSignature("anonymous function")
+	if err != nil {
+		return nil, err
+	}
+
+	if len(parameters) > 50 {
+		tok := token
+		return nil, fmt.Errorf("functions don't support more than 50 arguments (was %d for anonymous function) at %d:%d", len(parameters), tok.Line, tok.Col)
+	}
+
+	// The function body is separate from the surrounding code, so e.g. 'exit loop' cannot refer to an outer loop
+	parsingFunctionDeclaration, loopBodyCount, variables := p.parsingFunctionDeclaration, p.loopBodyCount, p.variables
+	p.parsingFunctionDeclaration, p.loopBodyCount, p.variables = true, 0, functionVariables(parameters, outVariables)
+	body, err := p.parseBlock("function parameters")
+	if err != nil {
+		return nil, err
+	}
+	p.parsingFunctionDeclaration, p.loopBodyCount, p.variables = parsingFunctionDeclaration, loopBodyCount, variables
+
+	p.imports.anonymousFunctions += 1
+	identifier := token
+	identifier.Type = TokenIdentifier
+	identifier.Lexeme = anonymousFunctionPrefix + strconv.Itoa(p.imports.anonymousFunctions)
+
+	return &FunctionLiteralExpression{
+		Token: token,
+		Declaration: &FunctionDeclarationStatement{
+			Identifier:   identifier,
+			Parameters:   parameters,
+			OutVariables: outVariables,
+			Body:         body,
+		},
 	}, nil
 }
 
@@ -521,6 +1291,16 @@ func (p *Parser) parseAssignmentStatement() (Statement, error) {
 		return nil, err
 	}
 
+	if p.hasCurrent() && p.current().Type == TokenComma {
+		return p.parseDestructuringAssignment(startToken, left)
+	}
+
+	if p.hasCurrent() {
+		if _, found := compoundAssignmentOperators[p.current().Type]; found {
+			return p.parseCompoundAssignment(startToken, left)
+		}
+	}
+
 	if !p.hasCurrent() || p.current().Type != TokenEquals {
 		return &ExpressionStatement{startToken, left}, nil
 	}
@@ -557,11 +1337,120 @@ func (p *Parser) parseAssignmentStatement() (Statement, error) {
 		return nil, fmt.Errorf("expected variable expression on the left side of an assignment, but got '%v'", reflect.TypeOf(left))
 	}
 
+	p.declareVariable(variable.Token)
 	return &AssignmentStatement{Identifier: variable.Token, Expression: right}, nil
 }
 
+var compoundAssignmentOperators = map[TokenType]TokenType{
+	TokenPlusEquals:       TokenPlus,
+	TokenMinusEquals:      TokenMinus,
+	TokenAsteriskEquals:   TokenAsterisk,
+	TokenSlashEquals:      TokenSlash,
+	TokenPercentEquals:    TokenPercent,
+	TokenUnderscoreEquals: TokenUnderscore,
+}
+
+func (p *Parser) parseCompoundAssignment(startToken Token, left Expression) (Statement, error) {
+	// x += expression, [container]key += expression, or instance.field += expression
+	operator := p.current()
+	operator.Type = compoundAssignmentOperators[operator.Type]
+	operator.Lexeme = strings.TrimSuffix(operator.Lexeme, "=")
+	p.consume(1)
+
+	right, err := p.parseExpression()
+	if err != nil {
+		return nil, err
+	}
+
+	switch target := left.(type) {
+	case *VariableExpression:
+		// The variable is only read, so it's the same as `x = x + expression`
+		return &AssignmentStatement{Identifier: target.Token, Expression: &BinaryExpression{Left: target, Operator: operator, Right: right}}, nil
+	case *ContainerAccessExpression, *FieldAccessExpression:
+		return &CompoundAssignmentStatement{Token: startToken, Target: target, Operator: operator, Expression: right}, nil
+	}
+	lineCol := left.lineCol()
+	return nil, fmt.Errorf("expected variable, container element, or field on the left side of '%s=', but got '%v' at %d:%d", operator.Lexeme, reflect.TypeOf(left), lineCol.line, lineCol.col)
+}
+
+func (p *Parser) parseDestructuringAssignment(startToken Token, first Expression) (Statement, error) {
+	// a, b, c = expression
+	targets := []Expression{first}
+	for p.hasCurrent() && p.current().Type == TokenComma {
+		p.consume(1)
+		target, err := p.parseExpression()
+		if err != nil {
+			return nil, err
+		}
+		targets = append(targets, target)
+	}
+
+	identifiers := make([]Token, len(targets))
+	seen := make(map[string]struct{})
+	for i, target := range targets {
+		variable, ok := target.(*VariableExpression)
+		if !ok {
+			lineCol := target.lineCol()
+			return nil, fmt.Errorf("expected only variables on the left side of a destructuring assignment, but got '%v' at %d:%d", reflect.TypeOf(target), lineCol.line, lineCol.col)
+		}
+		if _, found := seen[variable.Token.Lexeme]; found {
+			tok := variable.Token
+			return nil, fmt.Errorf("variable '%v' assigned more than once in destructuring assignment at %d:%d", tok.Lexeme, tok.Line, tok.Col)
+		}
+		seen[variable.Token.Lexeme] = struct{}{}
+		identifiers[i] = variable.Token
+		p.declareVariable(variable.Token)
+	}
+
+	if !p.hasCurrent() || p.current().Type != TokenEquals {
+		tok := startToken
+		if p.hasCurrent() {
+			tok = p.current()
+		}
+		return nil, fmt.Errorf("expected '=' after variables of destructuring assignment but got '%v' at %d:%d", tok.Type, tok.Line, tok.Col)
+	}
+	p.consume(1)
+
+	expression, err := p.parseExpression()
+	if err != nil {
+		return nil, err
+	}
+
+	return &DestructuringAssignmentStatement{Token: startToken, Identifiers: identifiers, Expression: expression}, nil
+}
+
 func (p *Parser) parseExpression() (Expression, error) {
-	return p.parseLogicalOr()
+	return p.parseConditional()
+}
+
+func (p *Parser) parseConditional() (Expression, error) {
+	// then if condition otherwise otherwise
+	then, err := p.parseLogicalOr()
+	if err != nil || !p.hasCurrent() || p.current().Type != TokenIf {
+		return then, err
+	}
+
+	token := p.current()
+	p.consume(1)
+	condition, err := p.parseLogicalOr()
+	if err != nil {
+		return nil, err
+	}
+
+	if !p.hasCurrent() || p.current().Type != TokenOtherwise {
+		tok := token
+		if p.hasCurrent() {
+			tok = p.current()
+		}
+		return nil, fmt.Errorf("expected 'otherwise' after 'if' condition in expression at %d:%d", tok.Line, tok.Col)
+	}
+	p.consume(1)
+
+	otherwise, err := p.parseConditional()
+	if err != nil {
+		return nil, err
+	}
+	return &ConditionalExpression{Token: token, Condition: condition, Then: then, Otherwise: otherwise}, nil
 }
 
 func (p *Parser) parseLogicalOr() (Expression, error) {
@@ -625,20 +1514,58 @@ func (p *Parser) parseMultiply() (Expression, error) {
 }
 
 func (p *Parser) parseRemainder() (Expression, error) {
-	return p.parseBinary(TokenPercent, p.parseStringConcat)
+	return p.parseBinary(TokenPercent, p.parseShift)
+}
+
+// parseShift parses shl and shr at the same level, so that e.g. a shl b shr c is (a shl b) shr c
+func (p *Parser) parseShift() (Expression, error) {
+	return p.parseBinaryOneOf([]TokenType{TokenShl, TokenShr}, p.parseStringConcat)
 }
 
 func (p *Parser) parseStringConcat() (Expression, error) {
-	return p.parseBinary(TokenUnderscore, p.parseContainerAccess)
+	return p.parseBinary(TokenUnderscore, p.parseUnary)
+}
+
+func (p *Parser) parseUnary() (Expression, error) {
+	if !p.hasCurrent() || (p.current().Type != TokenBNot && p.current().Type != TokenMinus) {
+		return p.parseContainerAccess()
+	}
+
+	operator := p.current()
+	p.consume(1)
+	operand, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+
+	if literal, ok := operand.(*LiteralExpression); ok && operator.Type == TokenMinus && literal.Token.Type == TokenNumber {
+		// Negative number literal
+		negated, err := unaryArithmetic(literal.Token.Literal, operator.Lexeme)
+		if err != nil {
+			return nil, err
+		}
+		token := literal.Token
+		token.Lexeme = operator.Lexeme + token.Lexeme
+		token.Literal = negated
+		token.Pos, token.Line, token.Col = operator.Pos, operator.Line, operator.Col
+		return &LiteralExpression{Token: token}, nil
+	}
+
+	return &UnaryExpression{Operator: operator, Operand: operand}, nil
 }
 
 func (p *Parser) parseBinary(tokenType TokenType, down func() (Expression, error)) (Expression, error) {
+	return p.parseBinaryOneOf([]TokenType{tokenType}, down)
+}
+
+// parseBinaryOneOf parses left-associative binary expressions with any of the operators, which have the same precedence
+func (p *Parser) parseBinaryOneOf(tokenTypes []TokenType, down func() (Expression, error)) (Expression, error) {
 	left, err := down()
 	if err != nil {
 		return nil, err
 	}
 
-	for p.hasCurrent() && p.current().Type == tokenType {
+	for p.hasCurrent() && slices.Contains(tokenTypes, p.current().Type) {
 		operator := p.current()
 		p.consume(1)
 		right, err := down()
@@ -674,16 +1601,67 @@ func (p *Parser) parseContainerAccess() (Expression, error) {
 		}
 		p.consume(1)
 
-		indexExpr, err := p.parseFieldAccess()
+		innerExpression, err = p.parseContainerIndex(startToken, innerExpression)
 		if err != nil {
 			return nil, err
 		}
-
-		innerExpression = &ContainerAccessExpression{Token: startToken, Container: innerExpression, Access: indexExpr}
 	}
 	return innerExpression, nil
 }
 
+// parseContainerIndex parses the index after [container], which is either a single index or key, or a range
+// `start..end` where start and end are optional
+func (p *Parser) parseContainerIndex(startToken Token, container Expression) (Expression, error) {
+	var start Expression
+	if !p.hasCurrent() || p.current().Type != TokenDotDot {
+		// The index can be negative (e.g. [array]-1), so it can have a unary operator
+		index, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		if !p.hasCurrent() || p.current().Type != TokenDotDot {
+			return &ContainerAccessExpression{Token: startToken, Container: container, Access: index}, nil
+		}
+		start = index
+	}
+
+	rangeToken := p.current()
+	p.consume(1)
+
+	var end Expression
+	if p.hasCurrent() && startsOperand(p.current().Type) {
+		var err error
+		if end, err = p.parseUnary(); err != nil {
+			return nil, err
+		}
+	}
+
+	nilLiteral := func() Expression {
+		return &LiteralExpression{Token: Token{Type: TokenNil, Lexeme: "nil", Line: rangeToken.Line, Col: rangeToken.Col}}
+	}
+	if start == nil {
+		start = nilLiteral()
+	}
+	if end == nil {
+		end = nilLiteral()
+	}
+	return &FunctionCallExpression{
+		Token:        startToken,
+		Builtin:      true,
+		FunctionName: "slice",
+		Arguments:    []Expression{container, start, end},
+	}, nil
+}
+
+// startsOperand returns whether a token of the type can be the start of an operand (e.g. the end of a range)
+func startsOperand(tokenType TokenType) bool {
+	switch tokenType {
+	case TokenNumber, TokenString, TokenInterpolatedString, TokenNil, TokenIdentifier, TokenParenOpen, TokenBracketOpen, TokenMinus, TokenBNot:
+		return true
+	}
+	return false
+}
+
 func (p *Parser) parseFieldAccess() (Expression, error) {
 	left, err := p.parsePrimary()
 	if err != nil {
@@ -699,24 +1677,84 @@ func (p *Parser) parseFieldAccess() (Expression, error) {
 		identifier := p.next()
 		p.consume(2)
 
+		if p.hasCurrent() && p.current().Type == TokenParenOpen {
+			// instance.method(arguments)
+			p.consume(1)
+			arguments, names, err := p.parseArguments()
+			if err != nil {
+				return nil, err
+			}
+			if err := checkNoArgumentNames(names); err != nil {
+				return nil, err
+			}
+			left = &MethodCallExpression{Token: fullStop, Receiver: left, Method: identifier, Arguments: arguments}
+			continue
+		}
+
 		left = &FieldAccessExpression{Token: fullStop, Left: left, Identifier: identifier}
 	}
 
 	return left, nil
 }
 
+// parseInterpolatedString turns "a${b}c" into "a" _ string(b) _ "c"
+func (p *Parser) parseInterpolatedString(token Token) (Expression, error) {
+	var result Expression
+	for _, part := range token.Literal.([]StringPart) {
+		var expr Expression
+		if part.Tokens == nil {
+			textToken := token
+			textToken.Type, textToken.Lexeme, textToken.Literal = TokenString, part.Text, part.Text
+			expr = &LiteralExpression{Token: textToken}
+		} else {
+			// Parse the tokens of the interpolation as if they were the only tokens left
+			rest := p.tokens
+			p.tokens = part.Tokens
+			inner, err := p.parseExpression()
+			if err != nil {
+				return nil, err
+			}
+			if p.current().Type != TokenNewline {
+				tok := p.current()
+				return nil, fmt.Errorf("expected '}' after expression in string interpolation but got '%v' at %d:%d", tok.Type, tok.Line, tok.Col)
+			}
+			p.tokens = rest
+
+			callToken := part.Tokens[0]
+			callToken.Type, callToken.Lexeme, callToken.Literal = TokenIdentifier, "string", nil
+			expr = &FunctionCallExpression{Token: callToken, Builtin: true, FunctionName: "string", Arguments: []Expression{inner}}
+		}
+
+		if result == nil {
+			result = expr
+		} else {
+			concat := token
+			concat.Type, concat.Lexeme, concat.Literal = TokenUnderscore, "_", nil
+			result = &BinaryExpression{Left: result, Operator: concat, Right: expr}
+		}
+	}
+	return result, nil
+}
+
 func (p *Parser) parsePrimary() (Expression, error) {
 	if !p.hasCurrent() {
 		return nil, fmt.Errorf("expected primary expression but reached end of data")
 	}
 
 	token := p.current()
-	if token.Type == TokenString || token.Type == TokenNumber {
+	if token.Type == TokenString || token.Type == TokenNumber || token.Type == TokenNil {
 		p.consume(1)
 		return &LiteralExpression{Token: token}, nil
+	} else if token.Type == TokenInterpolatedString {
+		p.consume(1)
+		return p.parseInterpolatedString(token)
 	} else if token.Type == TokenIdentifier {
 		if p.left() >= 2 && p.next().Type == TokenParenOpen {
-			return p.parseFunctionCall()
+			return p.parseFunctionCall(token.Lexeme, 1)
+		}
+
+		if name, length := p.namespacedName(); length > 1 && p.left() > length && p.nextN(length).Type == TokenParenOpen {
+			return p.parseFunctionCall(name, length)
 		}
 
 		// Variable access
@@ -736,63 +1774,138 @@ func (p *Parser) parsePrimary() (Expression, error) {
 
 		p.consume(1)
 		return expr, nil
+	} else if token.Type == TokenAmpersand {
+		return p.parseFunctionReference()
+	} else if token.Type == TokenPipe {
+		return p.parseFunctionLiteral()
 	}
 
 	return nil, fmt.Errorf("expected primary expression but got %s ('%s') at %d:%d", token.Type, token.Lexeme, token.Line, token.Col)
 }
 
-func (p *Parser) parseFunctionCall() (Expression, error) {
+// namespacedName returns a name qualified by import namespaces (e.g. ns.f), and the amount of tokens making up that
+// name, which is 1 if the current token is not a namespace
+func (p *Parser) namespacedName() (string, int) {
+	name := p.current().Lexeme
+	length := 1
+	for p.left() >= length+2 && p.nextN(length).Type == TokenFullStop && p.nextN(length+1).Type == TokenIdentifier {
+		if _, found := p.namespaces[name]; !found {
+			break
+		}
+		name += "." + p.nextN(length+1).Lexeme
+		length += 2
+	}
+	return name, length
+}
+
+func (p *Parser) parseFunctionReference() (Expression, error) {
+	// &function or &namespace.function
+	token := p.current()
+	if !p.hasNext() || p.next().Type != TokenIdentifier {
+		tok := p.next()
+		return nil, fmt.Errorf("expected function name after '&' but got '%v' at %d:%d", tok.Type, tok.Line, tok.Col)
+	}
+	p.consume(1)
+
+	identifierToken := p.current()
+	identifier, length := p.namespacedName()
+	p.consume(length)
+
+	// Resolved once all functions are declared, because a declared function takes precedence over a builtin
+	identifierToken.Lexeme = identifier
+	reference := &FunctionReferenceExpression{Token: token, FunctionName: p.namespace + identifier}
+	if _, found := builtins[identifier]; found {
+		reference.Builtin, reference.FunctionName = true, identifier
+	}
+	p.forwardReferences = append(p.forwardReferences, ForwardReference{Token: identifierToken, Reference: reference})
+	return reference, nil
+}
+
+func (p *Parser) parseFunctionCall(identifier string, nameLength int) (Expression, error) {
 	callToken := p.current()
-	identifier := callToken.Lexeme
+	callToken.Lexeme = identifier
 
-	builtin, builtinFound := builtins[identifier]
+	_, builtinFound := builtins[identifier]
 	functionArity, functionFound := p.declaredFunctions[identifier]
 	_, constructor := p.declaredTypes[identifier]
-	if builtinFound {
-		// This is safe, because parseFunctionDeclaration disallows overwriting builtin functions
-		functionArity = builtin.Arity
+	if functionFound {
+		// Functions and types declared in the script take precedence over builtins with the same name; calls of
+		// builtins are resolved at the end, when it's known whether the script declares a function with that name
+		builtinFound = false
+	}
+
+	p.consume(nameLength + 1) // Consume identifier and '('
+
+	arguments, names, err := p.parseArguments()
+	if err != nil {
+		return nil, err
+	}
+
+	if functionFound && !constructor {
+		if err := checkNoArgumentNames(names); err != nil {
+			return nil, err
+		}
+		if len(arguments) != functionArity && functionArity != ArityVariadic {
+			tok := p.current()
+			return nil, fmt.Errorf("expected %d arguments but got %d for function '%s' at %d:%d", functionArity, len(arguments), identifier, tok.Line, tok.Col)
+		}
+	}
+
+	functionName := identifier
+	if !builtinFound {
+		functionName = p.namespace + identifier
 	}
 
-	p.consume(2) // Consume identifier and '('
+	call := &FunctionCallExpression{
+		Token:        callToken,
+		Builtin:      builtinFound,
+		Constructor:  constructor,
+		FunctionName: functionName,
+		Arguments:    arguments,
+	}
 
+	if constructor {
+		if err := resolveConstructorArguments(call, names, p.declaredTypes[identifier]); err != nil {
+			return nil, err
+		}
+	} else if !functionFound {
+		p.forwardCalls = append(p.forwardCalls, ForwardCall{Token: callToken, ArgumentCount: len(arguments), ArgumentNames: names, Call: call, Variables: p.variables})
+	}
+	return call, nil
+}
+
+// parseArguments parses the arguments of a call, up to and including the closing ')'; it also returns the name of
+// every argument, which is an empty token for arguments that are not named (e.g. `Item(id: 1)`)
+func (p *Parser) parseArguments() ([]Expression, []Token, error) {
 	arguments := make([]Expression, 0)
+	names := make([]Token, 0)
 	for p.hasCurrent() {
-		// TODO: remove duplication
 		if p.current().Type == TokenParenClose {
 			p.consume(1)
-			break
+			return arguments, names, nil
+		}
+
+		name := Token{}
+		if p.left() >= 2 && p.current().Type == TokenIdentifier && p.next().Type == TokenColon {
+			name = p.current()
+			p.consume(2)
 		}
 
 		expr, err := p.parseExpression()
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 
 		arguments = append(arguments, expr)
+		names = append(names, name)
 		if p.hasCurrent() {
 			if p.current().Type == TokenComma {
 				p.consume(1)
 			} else if p.current().Type != TokenParenClose {
 				tok := p.current()
-				return nil, fmt.Errorf("expected ')' or ',' but got %s ('%s') at %d:%d", tok.Type, tok.Lexeme, tok.Line, tok.Col)
+				return nil, nil, fmt.Errorf("expected ')' or ',' but got %s ('%s') at %d:%d", tok.Type, tok.Lexeme, tok.Line, tok.Col)
 			}
-		} else {
-			return nil, fmt.Errorf("expected ')' or ',' but got end of input")
 		}
 	}
-
-	if !builtinFound && !functionFound {
-		p.forwardCalls = append(p.forwardCalls, ForwardCall{Token: callToken, ArgumentCount: len(arguments)})
-	} else if len(arguments) != functionArity && functionArity != ArityVariadic {
-		tok := p.current()
-		return nil, fmt.Errorf("expected %d arguments but got %d for function '%s' at %d:%d", functionArity, len(arguments), identifier, tok.Line, tok.Col)
-	}
-
-	return &FunctionCallExpression{
-		Token:        callToken,
-		Builtin:      builtinFound,
-		Constructor:  constructor,
-		FunctionName: callToken.Lexeme,
-		Arguments:    arguments,
-	}, nil
+	return nil, nil, fmt.Errorf("expected ')' or ',' but got end of input")
 }
@@ -12,21 +12,47 @@ func TestToi(t *testing.T) {
 	}{
 		{"arrays", ""},
 		{"assignment", ""},
+		{"attempt", ""},
+		{"bigNumbers", ""},
 		{"binaryOperators", ""},
+		{"bitwise", ""},
 		{"builtinFuncs", "10\n20"},
 		{"comment", ""},
+		{"compoundAssignment", ""},
+		{"conditionalExpression", ""},
 		{"conditionals", ""},
+		{"constructors", ""},
+		{"equality", ""},
 		{"for", ""},
 		{"functions", ""},
+		{"heap", ""},
+		{"higherOrderFunctions", ""},
 		{"if", ""},
+		{"import", ""},
+		{"interpolation", ""},
 		{"inputLines", "asdf\nkek"},
+		{"iterators", "first\nsecond"},
 		{"logicalOperators", ""},
 		{"loops", ""},
 		{"maps", ""},
+		{"match", ""},
 		{"math", ""},
+		{"mathBuiltins", ""},
+		{"mathHelpers", ""},
+		{"methods", ""},
+		{"nil", ""},
 		{"printNumbers", ""},
+		{"rangeFor", ""},
+		{"regex", ""},
+		{"sets", ""},
+		{"sort", ""},
+		{"slicing", ""},
+		{"stringLiterals", ""},
 		{"strings", ""},
+		{"stringBuiltins", ""},
+		{"tuples", ""},
 		{"types", ""},
+		{"unaryMinus", ""},
 		{"while", ""},
 	}
 
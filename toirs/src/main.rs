@@ -49,6 +49,10 @@ fn parse_constants(lines: &[String]) -> (Vec<Constant>, &[String]) {
             Constant::Number(parts[1].parse().unwrap())
         } else if parts[0] == "string" {
             Constant::String(parts[1].into())
+        } else if parts[0] == "bigint" {
+            panic!("big integers are not supported: {}", parts[1])
+        } else if parts[0] == "set" {
+            panic!("sets are not supported")
         } else {
             panic!("unsupported type {}", parts[0])
         };
@@ -74,7 +78,8 @@ fn parse_functions(lines: &[String]) -> (Vec<FunctionDefinition>, &[String]) {
 
 fn parse_function(lines: &[String]) -> (FunctionDefinition, &[String]) {
     let name = &lines[0];
-    let has_out_var: bool = lines[1].parse().unwrap();
+    let out_var_count: usize = lines[1].parse().unwrap();
+    let has_out_var = out_var_count > 0;
 
     let rest: &[String] = &lines[2..];
     let (parameters, rest) = parse_strings("parameters", &rest);
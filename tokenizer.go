@@ -2,18 +2,22 @@ package main
 
 import (
 	"fmt"
+	"math/big"
+	"slices"
 	"strconv"
 	"strings"
+	"unicode/utf8"
 )
 
 type TokenType string
 
 const (
-	TokenNewline         TokenType = "Newline"
-	TokenIdentifier      TokenType = "Identifier"
-	TokenNumber          TokenType = "Number"
-	TokenString          TokenType = "String"
-	TokenInternalLiteral TokenType = "InternalLiteral"
+	TokenNewline            TokenType = "Newline"
+	TokenIdentifier         TokenType = "Identifier"
+	TokenNumber             TokenType = "Number"
+	TokenString             TokenType = "String"
+	TokenInterpolatedString TokenType = "InterpolatedString"
+	TokenInternalLiteral    TokenType = "InternalLiteral"
 
 	TokenEqualEqual   TokenType = "EqualEqual"
 	TokenNotEqual     TokenType = "NotEqual"
@@ -37,9 +41,19 @@ const (
 	TokenBOr  TokenType = "BOr"
 	TokenXOr  TokenType = "XOr"
 	TokenBAnd TokenType = "BAnd"
+	TokenBNot TokenType = "BNot"
+	TokenShl  TokenType = "Shl"
+	TokenShr  TokenType = "Shr"
 
 	TokenEquals TokenType = "Equals"
 
+	TokenPlusEquals       TokenType = "PlusEquals"
+	TokenMinusEquals      TokenType = "MinusEquals"
+	TokenAsteriskEquals   TokenType = "AsteriskEquals"
+	TokenSlashEquals      TokenType = "SlashEquals"
+	TokenPercentEquals    TokenType = "PercentEquals"
+	TokenUnderscoreEquals TokenType = "UnderscoreEquals"
+
 	TokenParenOpen    TokenType = "ParenOpen"
 	TokenParenClose   TokenType = "ParenClose"
 	TokenBraceOpen    TokenType = "BraceOpen"
@@ -48,11 +62,14 @@ const (
 	TokenBracketClose TokenType = "BracketClose"
 
 	TokenComma TokenType = "Comma"
+	TokenColon TokenType = "Colon"
 
 	TokenIf        TokenType = "If"
 	TokenOtherwise TokenType = "Otherwise"
 	TokenWhile     TokenType = "While"
 	TokenFor       TokenType = "For"
+	TokenAttempt   TokenType = "Attempt"
+	TokenFailure   TokenType = "Failure"
 
 	TokenExit      TokenType = "Exit"
 	TokenFunction  TokenType = "Function"
@@ -61,6 +78,12 @@ const (
 	TokenIteration TokenType = "Iteration"
 
 	TokenFullStop TokenType = "FullStop"
+	TokenDotDot   TokenType = "DotDot"
+
+	TokenImport TokenType = "Import"
+	TokenAs     TokenType = "As"
+
+	TokenNil TokenType = "Nil"
 )
 
 type Token struct {
@@ -86,6 +109,7 @@ var singleCharTokens = map[rune]TokenType{
 	']': TokenBracketClose,
 
 	',': TokenComma,
+	':': TokenColon,
 
 	'+': TokenPlus,
 	'_': TokenUnderscore,
@@ -99,11 +123,23 @@ var singleCharTokens = map[rune]TokenType{
 	'.': TokenFullStop,
 }
 
+// compoundAssignmentTokens are the operators that can be followed by '=' to form a compound assignment, e.g. '+='
+var compoundAssignmentTokens = map[rune]TokenType{
+	'+': TokenPlusEquals,
+	'-': TokenMinusEquals,
+	'*': TokenAsteriskEquals,
+	'/': TokenSlashEquals,
+	'%': TokenPercentEquals,
+	'_': TokenUnderscoreEquals,
+}
+
 var keywordTokens = map[string]TokenType{
 	"if":        TokenIf,
 	"otherwise": TokenOtherwise,
 	"while":     TokenWhile,
 	"for":       TokenFor,
+	"attempt":   TokenAttempt,
+	"failure":   TokenFailure,
 	"exit":      TokenExit,
 	"function":  TokenFunction,
 	"loop":      TokenLoop,
@@ -114,6 +150,12 @@ var keywordTokens = map[string]TokenType{
 	"bor":       TokenBOr,
 	"xor":       TokenXOr,
 	"band":      TokenBAnd,
+	"bnot":      TokenBNot,
+	"shl":       TokenShl,
+	"shr":       TokenShr,
+	"import":    TokenImport,
+	"as":        TokenAs,
+	"nil":       TokenNil,
 }
 
 func tokenize(input string) (tokens []Token, errors []error) {
@@ -136,6 +178,20 @@ func tokenize(input string) (tokens []Token, errors []error) {
 		c := runes[i]
 		col += 1
 
+		if c == '.' && i != len(runes)-1 && runes[i+1] == '.' {
+			addToken(Token{TokenDotDot, "..", nil, i, line, col})
+			i += 1
+			col += 1
+			continue
+		}
+
+		if tokenType, found := compoundAssignmentTokens[c]; found && i != len(runes)-1 && runes[i+1] == '=' {
+			addToken(Token{tokenType, string(runes[i : i+2]), nil, i, line, col})
+			i += 1
+			col += 1
+			continue
+		}
+
 		tokenType, found := singleCharTokens[c]
 		if found {
 			addToken(Token{tokenType, string(c), nil, i, line, col})
@@ -196,15 +252,22 @@ func tokenize(input string) (tokens []Token, errors []error) {
 			} else {
 				addToken(Token{TokenLessThan, "<", nil, i, line, col})
 			}
-		case c == '"':
-			token, err := tokenizeString(runes[i+1:], i, line, col)
+		case c == '"' || c == '`':
+			token, end, err := tokenizeString(runes[i:], i, line, col)
 			if err != nil {
 				addError(err)
-				break
+				if end == 0 {
+					// The string is unterminated, so the rest of the input is part of it
+					return
+				}
+			} else {
+				addToken(token)
 			}
-			addToken(token)
-			i += len(token.Lexeme) + 1
-			col += len(token.Lexeme) + 1
+			// Strings can span multiple lines, so keep track of the line and col of the end of the string
+			for _, r := range runes[i : i+end] {
+				line, col = advanceLineCol(r, line, col)
+			}
+			i += end
 		case isDigit(c):
 			token, err := tokenizeNumber(runes[i:], i, line, col)
 			if err != nil {
@@ -237,29 +300,278 @@ func tokenize(input string) (tokens []Token, errors []error) {
 	return
 }
 
-func tokenizeString(runes []rune, pos, line, col int) (Token, error) {
-	i := 0
-	for i < len(runes) {
-		if runes[i] == '"' {
-			break
+// StringPart is a part of a string with interpolations: either text, or the tokens of an ${expression}
+type StringPart struct {
+	Text   string
+	Tokens []Token
+}
+
+const tripleQuote = `"""`
+
+// tokenizeString tokenizes a string literal starting at its opening delimiter: a "string", a """multi-line string""",
+// or a `raw string`. It returns the index of the last rune of the literal, also along with an error in its content,
+// so that tokenizing can continue after it. A string with ${expression} interpolations results in a
+// TokenInterpolatedString with the parts as its literal.
+func tokenizeString(runes []rune, pos, line, col int) (Token, int, error) {
+	if runes[0] == '`' {
+		// Raw strings have no escape sequences or interpolations
+		end := slices.Index(runes[1:], '`') + 1
+		if end == 0 {
+			return Token{}, 0, fmt.Errorf("unterminated raw string at %d:%d", line, col)
 		}
-		if i < len(runes)-4 && runes[i] == '$' && runes[i+1] == '{' && runes[i+2] == '"' && runes[i+3] == '}' {
+		text := string(runes[1:end])
+		return Token{TokenString, string(runes[:end+1]), text, pos, line, col}, end, nil
+	}
+
+	delimiter := `"`
+	if hasRunePrefix(runes, tripleQuote) {
+		delimiter = tripleQuote
+	}
+	from := len(delimiter)
+	to, err := findStringEnd(runes, from, delimiter, line, col)
+	if err != nil {
+		return Token{}, 0, err
+	}
+	end := to + len(delimiter) - 1
+
+	fromLine, fromCol := line, col+from
+	indent := 0
+	if delimiter == tripleQuote {
+		from, to, indent = trimIndent(runes, from, to)
+		for i := len(delimiter); i < from; i++ {
+			fromLine, fromCol = advanceLineCol(runes[i], fromLine, fromCol)
+		}
+	}
+
+	parts, err := tokenizeStringContent(runes[from:to], indent, pos+from, fromLine, fromCol)
+	if err != nil {
+		return Token{}, end, err
+	}
+
+	lexeme := string(runes[:end+1])
+	if len(parts) == 0 {
+		return Token{TokenString, lexeme, "", pos, line, col}, end, nil
+	} else if len(parts) == 1 && parts[0].Tokens == nil {
+		return Token{TokenString, lexeme, parts[0].Text, pos, line, col}, end, nil
+	}
+	return Token{TokenInterpolatedString, lexeme, parts, pos, line, col}, end, nil
+}
+
+func hasRunePrefix(runes []rune, prefix string) bool {
+	return len(runes) >= len(prefix) && string(runes[:len(prefix)]) == prefix
+}
+
+func advanceLineCol(c rune, line, col int) (int, int) {
+	if c == '\n' {
+		return line + 1, 1
+	}
+	return line, col + 1
+}
+
+// findStringEnd returns the index of the closing delimiter, skipping over escape sequences and interpolations
+func findStringEnd(runes []rune, from int, delimiter string, line, col int) (int, error) {
+	for i := from; i < len(runes); i++ {
+		if runes[i] == '\\' {
+			i += 1
+		} else if hasRunePrefix(runes[i:], "${\"}") {
 			i += 3
+		} else if hasRunePrefix(runes[i:], "${") {
+			end, err := findInterpolationEnd(runes, i+2, line, col)
+			if err != nil {
+				return 0, err
+			}
+			i = end
+		} else if hasRunePrefix(runes[i:], delimiter) {
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("unterminated string at %d:%d", line, col)
+}
+
+// trimIndent removes the (whitespace-only) first and last lines of a multi-line string, and returns the
+// indentation that all other lines have in common
+func trimIndent(runes []rune, from, to int) (int, int, int) {
+	isBlank := func(line []rune) bool {
+		return !slices.ContainsFunc(line, func(c rune) bool { return c != ' ' && c != '\t' })
+	}
+
+	if firstNewline := slices.Index(runes[from:to], '\n'); firstNewline != -1 && isBlank(runes[from:from+firstNewline]) {
+		from += firstNewline + 1
+	}
+	lastNewline := to - 1
+	for lastNewline >= from && runes[lastNewline] != '\n' {
+		lastNewline -= 1
+	}
+	if lastNewline >= from && isBlank(runes[lastNewline+1:to]) {
+		to = lastNewline
+	}
+
+	indent := -1
+	for start := from; start < to; {
+		length := slices.Index(runes[start:to], '\n')
+		if length == -1 {
+			length = to - start
+		}
+		line := runes[start : start+length]
+		if !isBlank(line) {
+			lineIndent := slices.IndexFunc(line, func(c rune) bool { return c != ' ' && c != '\t' })
+			if indent == -1 || lineIndent < indent {
+				indent = lineIndent
+			}
 		}
-		i += 1
+		start += length + 1
 	}
+	return from, to, max(indent, 0)
+}
+
+var escapeSequences = map[rune]rune{'n': '\n', 't': '\t', 'r': '\r', '\\': '\\', '"': '"', '$': '$'}
 
-	if i == len(runes) || runes[i] != '"' {
-		return Token{}, fmt.Errorf("unterminated string at %d:%d", line, col)
+// tokenizeStringContent splits the content of a string into text and ${expression} parts, replacing escape
+// sequences and removing indent whitespace from the start of each line
+func tokenizeStringContent(runes []rune, indent, pos, line, col int) ([]StringPart, error) {
+	var parts []StringPart
+	text := &strings.Builder{}
+
+	// Position of the current rune, to report errors and calculate the positions of the tokens in interpolations
+	currentLine, currentCol := line, col
+	advance := func(to int, i int) int {
+		for ; i < to; i++ {
+			currentLine, currentCol = advanceLineCol(runes[i], currentLine, currentCol)
+		}
+		return i
+	}
+
+	skipIndent := func(i int) int {
+		for j := 0; j < indent && i < len(runes) && (runes[i] == ' ' || runes[i] == '\t'); j++ {
+			i = advance(i+1, i)
+		}
+		return i
 	}
 
-	lexeme := string(runes[0:i])
-	literal := strings.ReplaceAll(lexeme, "${\"}", "\"")
+	for i := skipIndent(0); i < len(runes); {
+		c := runes[i]
+		if c == '\\' {
+			r, length, err := parseEscapeSequence(runes[i:], currentLine, currentCol)
+			if err != nil {
+				return nil, err
+			}
+			text.WriteRune(r)
+			i = advance(i+length, i)
+		} else if hasRunePrefix(runes[i:], "${\"}") {
+			// ${"} is an escaped double quote
+			text.WriteRune('"')
+			i = advance(i+4, i)
+		} else if hasRunePrefix(runes[i:], "${") {
+			start := i + 2
+			end, err := findInterpolationEnd(runes, start, currentLine, currentCol)
+			if err != nil {
+				return nil, err
+			}
+			tokens, err := tokenizeInterpolation(runes[start:end], pos+start, currentLine, currentCol+2)
+			if err != nil {
+				return nil, err
+			}
 
-	return Token{TokenString, lexeme, literal, pos, line, col}, nil
+			if text.Len() != 0 {
+				parts = append(parts, StringPart{Text: text.String()})
+				text.Reset()
+			}
+			parts = append(parts, StringPart{Tokens: tokens})
+			i = advance(end+1, i)
+		} else {
+			text.WriteRune(c)
+			i = advance(i+1, i)
+			if c == '\n' {
+				i = skipIndent(i)
+			}
+		}
+	}
+
+	if text.Len() != 0 {
+		parts = append(parts, StringPart{Text: text.String()})
+	}
+	return parts, nil
 }
 
+// parseEscapeSequence parses the escape sequence at the start of runes, and returns the rune and the length of the
+// escape sequence
+func parseEscapeSequence(runes []rune, line, col int) (rune, int, error) {
+	if len(runes) < 2 {
+		return 0, 0, fmt.Errorf("unterminated escape sequence in string at %d:%d", line, col)
+	}
+	if r, found := escapeSequences[runes[1]]; found {
+		return r, 2, nil
+	}
+	if runes[1] != 'u' {
+		return 0, 0, fmt.Errorf("unknown escape sequence '\\%c' in string at %d:%d", runes[1], line, col)
+	}
+
+	// \u{1F600}
+	end := slices.Index(runes, '}')
+	if len(runes) < 4 || runes[2] != '{' || end == -1 {
+		return 0, 0, fmt.Errorf("expected '\\u{hexadecimal code point}' in string at %d:%d", line, col)
+	}
+	codePoint, err := strconv.ParseInt(string(runes[3:end]), 16, 32)
+	if err != nil || !utf8.ValidRune(rune(codePoint)) {
+		return 0, 0, fmt.Errorf("invalid code point '%s' in string at %d:%d", string(runes[3:end]), line, col)
+	}
+	return rune(codePoint), end + 1, nil
+}
+
+// findInterpolationEnd returns the index of the '}' that closes the interpolation starting at start, skipping over
+// nested braces and strings
+func findInterpolationEnd(runes []rune, start, line, col int) (int, error) {
+	depth := 0
+	for j := start; j < len(runes); j++ {
+		switch runes[j] {
+		case '"', '`':
+			// An error in the content of the nested string is reported when the interpolation is tokenized
+			_, end, err := tokenizeString(runes[j:], 0, line, col)
+			if end == 0 {
+				return 0, err
+			}
+			j += end
+		case '{':
+			depth += 1
+		case '}':
+			if depth == 0 {
+				return j, nil
+			}
+			depth -= 1
+		case '\n', '\r':
+			return 0, fmt.Errorf("unterminated interpolation in string at %d:%d", line, col)
+		}
+	}
+	return 0, fmt.Errorf("unterminated interpolation in string at %d:%d", line, col)
+}
+
+// tokenizeInterpolation tokenizes the expression of an interpolation, and moves the tokens to the position of the
+// expression in the script; the closing '}' becomes a newline token, so the parser knows where the expression ends
+func tokenizeInterpolation(runes []rune, pos, line, col int) ([]Token, error) {
+	tokens, errs := tokenize(string(runes))
+	if len(errs) != 0 {
+		return nil, fmt.Errorf("error in interpolation in string at %d:%d: %w", line, col, errs[0])
+	}
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("empty interpolation in string at %d:%d", line, col)
+	}
+	for i := range tokens {
+		tokens[i].Pos += pos
+		tokens[i].Line = line
+		tokens[i].Col += col - 1
+	}
+	return append(tokens, Token{TokenNewline, "}", nil, pos + len(runes), line, col + len(runes)}), nil
+}
+
+var numberBases = map[rune]int{'x': 16, 'X': 16, 'b': 2, 'B': 2, 'o': 8, 'O': 8}
+
 func tokenizeNumber(runes []rune, pos, line, col int) (Token, error) {
+	if len(runes) >= 2 && runes[0] == '0' {
+		if base, found := numberBases[runes[1]]; found {
+			return tokenizePrefixedNumber(runes, base, pos, line, col)
+		}
+	}
+
 	i := 0
 	for ; i < len(runes) && (isDigit(runes[i]) || runes[i] == '\''); i++ {
 	}
@@ -274,10 +586,15 @@ func tokenizeNumber(runes []rune, pos, line, col int) (Token, error) {
 
 	rawLexeme := string(runes[0:i])
 	fixedLexeme := strings.ReplaceAll(rawLexeme, "'", "")
+	var literal any
 	literal, err := strconv.Atoi(fixedLexeme)
 	if err != nil {
-		// TODO: better errors for really big numbers
-		return Token{}, fmt.Errorf("error converting '%s' to int: %v", fixedLexeme, err)
+		// Numbers that don't fit in an int are big ints
+		b, ok := new(big.Int).SetString(fixedLexeme, 10)
+		if !ok {
+			return Token{}, fmt.Errorf("error converting '%s' to int: %v", fixedLexeme, err)
+		}
+		literal = b
 	}
 
 	if len(fixedLexeme) > 1 && fixedLexeme[0] == '0' && fixedLexeme[1] != '.' {
@@ -287,6 +604,26 @@ func tokenizeNumber(runes []rune, pos, line, col int) (Token, error) {
 	return Token{TokenNumber, rawLexeme, literal, pos, line, col}, nil
 }
 
+// tokenizePrefixedNumber tokenizes hexadecimal (0x), binary (0b), and octal (0o) numbers
+func tokenizePrefixedNumber(runes []rune, base int, pos, line, col int) (Token, error) {
+	i := 2 // Skip the prefix
+	for ; i < len(runes) && (isDigit(runes[i]) || isLetter(runes[i]) || runes[i] == '\''); i++ {
+	}
+
+	rawLexeme := string(runes[0:i])
+	digits := strings.ReplaceAll(rawLexeme[2:], "'", "")
+	b, ok := new(big.Int).SetString(digits, base)
+	if !ok || digits[0] == '+' || digits[0] == '-' {
+		return Token{}, fmt.Errorf("invalid base %d number '%s' at %d:%d", base, rawLexeme, line, col)
+	}
+
+	return Token{TokenNumber, rawLexeme, normalizeBig(b), pos, line, col}, nil
+}
+
 func isDigit(c rune) bool {
 	return c >= '0' && c <= '9'
 }
+
+func isLetter(c rune) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
@@ -28,6 +28,22 @@ const (
 	OpFieldAccess
 	OpSetField
 	OpDuplicate
+	OpFunctionReference
+	OpBuiltinReference
+	OpCallValue
+	OpDestructure
+	OpLoadNil
+	OpCallMethod
+	OpBitwiseNot
+	OpNegate
+	OpDuplicatePair
+	OpRangeCheck
+	OpIterator
+	OpIteratorNext
+	OpMatchShape
+	OpJumpIfTrue
+	OpAttempt
+	OpEndAttempt
 
 	InvalidOp
 )
@@ -53,6 +69,9 @@ const (
 	OpBinaryBinaryAnd
 
 	OpBinaryConcat
+
+	OpBinaryShiftLeft
+	OpBinaryShiftRight
 )
 
 type VmType struct {
@@ -86,7 +105,58 @@ type VmFunction struct {
 	params              []string
 	ops                 []byte
 	variableDefinitions []string
-	hasOutVar           bool
+	outVarCount         int
+	positions           []StatementPosition
+}
+
+// VmFunctionValue is a function value, created by a function reference or an anonymous function
+type VmFunctionValue struct {
+	name string
+	vm   *Vm // for the constants, functions, and types available to the function
+}
+
+func (f *VmFunctionValue) call(arguments []any) (any, error) {
+	return f.callWithStack(arguments, make([]any, maxStack))
+}
+
+func (f *VmFunctionValue) callWithStack(arguments []any, stack []any) (any, error) {
+	function, found := f.vm.functions[f.name]
+	if !found {
+		return nil, fmt.Errorf("function '%v' not found", f.name)
+	}
+	if len(arguments) != len(function.params) {
+		return nil, fmt.Errorf("expected %d arguments but got %d for function '%s'", len(function.params), len(arguments), f.name)
+	}
+	functionVariables := function.newVariables()
+	copy(functionVariables, arguments)
+	return f.vm.callFunction(function, functionVariables, stack)
+}
+
+func (f *VmFunctionValue) print(out *bytes.Buffer) {
+	printFunction(f.name, out)
+}
+
+// undefinedVariable marks variables that have not been set yet, because nil is a valid value for a variable
+type undefinedVariable struct{}
+
+var undefined = undefinedVariable{}
+
+func newUndefinedVariables(count int) []any {
+	variables := make([]any, count)
+	for i := range variables {
+		variables[i] = undefined
+	}
+	return variables
+}
+
+// newVariables returns the variables for a call to the function; the out-variables start as nil, and all other
+// variables as undefined (the caller sets the parameters)
+func (function VmFunction) newVariables() []any {
+	variables := newUndefinedVariables(len(function.variableDefinitions))
+	for i := range function.outVarCount {
+		variables[len(function.params)+i] = nil
+	}
+	return variables
 }
 
 type Vm struct {
@@ -96,12 +166,13 @@ type Vm struct {
 	variables           []any
 	functions           map[string]VmFunction
 	types               map[string]VmType
+	positions           []StatementPosition
 }
 
 const maxStack = 50
 
-func execute(ops []byte, constants []any, variableDefinitions []string, functions map[string]VmFunction, types map[string]VmType) error {
-	variables := make([]any, len(variableDefinitions))
+func execute(ops []byte, constants []any, variableDefinitions []string, functions map[string]VmFunction, types map[string]VmType, positions []StatementPosition) error {
+	variables := newUndefinedVariables(len(variableDefinitions))
 	vm := &Vm{
 		ops:                 ops,
 		constants:           constants,
@@ -109,12 +180,26 @@ func execute(ops []byte, constants []any, variableDefinitions []string, function
 		types:               types,
 		variableDefinitions: variableDefinitions,
 		variables:           variables,
+		positions:           positions,
 	}
 	stack := make([]any, maxStack)
 	err := vm.execute(stack)
 	return err
 }
 
+// findMethod returns the method of a type instance bound to that instance, for builtins that call methods
+func (vm *Vm) findMethod(v any, name string) (callable, bool) {
+	instance, ok := v.(*VmInstance)
+	if !ok {
+		return nil, false
+	}
+	methodName := instance.vmType.Name + "." + name
+	if _, found := vm.functions[methodName]; !found {
+		return nil, false
+	}
+	return &BoundMethod{name: methodName, receiver: instance, method: &VmFunctionValue{name: methodName, vm: vm}}, true
+}
+
 func (vm *Vm) execute(stack []any) error {
 	constants, ops, functions, types := vm.constants, vm.ops, vm.functions, vm.types
 
@@ -128,7 +213,7 @@ func (vm *Vm) execute(stack []any) error {
 		constant := constants[index]
 		constantString, ok := constant.(string)
 		if !ok {
-			return "", fmt.Errorf("expected constant %d to be a string, but was '%v' at %d", index, constant, ip)
+			return "", fmt.Errorf("expected constant %d to be a string, but was '%v'", index, constant)
 		}
 		return constantString, nil
 	}
@@ -149,231 +234,488 @@ func (vm *Vm) execute(stack []any) error {
 		stackNext += 1
 	}
 
-	for ip < len(ops) {
-		instruction := readOpByte()
-
-		switch instruction {
-		case OpPop:
-			_ = popStack()
-		case OpBinary:
-			binop := readOpByte()
-			right := popStack()
-			left := popStack()
-
-			var result any
-			var err error
-
-			switch binop {
-			case OpBinaryPlus:
-				result, err = intBinaryOp(left, right, "+", func(l int, r int) int { return l + r })
-			case OpBinarySubtract:
-				result, err = intBinaryOp(left, right, "-", func(l int, r int) int { return l - r })
-			case OpBinaryMultiply:
-				result, err = intBinaryOp(left, right, "*", func(l int, r int) int { return l * r })
-			case OpBinaryDivide:
-				result, err = intBinaryOp(left, right, "/", func(l int, r int) int { return l / r })
-			case OpBinaryRemainder:
-				result, err = intBinaryOp(left, right, "%", func(l int, r int) int { return l % r })
-			case OpBinaryBinaryAnd:
-				result, err = intBinaryOp(left, right, "%", func(l int, r int) int { return l & r })
-			case OpBinaryBinaryOr:
-				result, err = intBinaryOp(left, right, "%", func(l int, r int) int { return l | r })
-			case OpBinaryBinaryXor:
-				result, err = intBinaryOp(left, right, "%", func(l int, r int) int { return l ^ r })
-
-			case OpBinaryEqual:
-				result = boolToInt(isEqual(left, right))
-			case OpBinaryGreaterThan:
-				result, err = intBinaryOp(left, right, ">", func(l int, r int) int { return boolToInt(l > r) })
-			case OpBinaryLessThan:
-				result, err = intBinaryOp(left, right, "<", func(l int, r int) int { return boolToInt(l < r) })
-
-			case OpBinaryConcat:
-				result, err = stringConcat(left, right)
+	// An error continues in the failure block of the innermost attempt block, with the error value on the stack
+	var handlers []attemptHandler
+	var failure error
+	for {
+	dispatch:
+		for ip < len(ops) {
+			instruction := readOpByte()
+
+			switch instruction {
+			case OpPop:
+				_ = popStack()
+			case OpBinary:
+				binop := readOpByte()
+				right := popStack()
+				left := popStack()
+
+				var result any
+				var err error
+
+				switch binop {
+				case OpBinaryPlus:
+					result, err = arithmetic(left, right, "+")
+				case OpBinarySubtract:
+					result, err = arithmetic(left, right, "-")
+				case OpBinaryMultiply:
+					result, err = arithmetic(left, right, "*")
+				case OpBinaryDivide:
+					result, err = arithmetic(left, right, "/")
+				case OpBinaryRemainder:
+					result, err = arithmetic(left, right, "%")
+				case OpBinaryBinaryAnd:
+					result, err = arithmetic(left, right, "band")
+				case OpBinaryBinaryOr:
+					result, err = arithmetic(left, right, "bor")
+				case OpBinaryBinaryXor:
+					result, err = arithmetic(left, right, "xor")
+				case OpBinaryShiftLeft:
+					result, err = arithmetic(left, right, "shl")
+				case OpBinaryShiftRight:
+					result, err = arithmetic(left, right, "shr")
+
+				case OpBinaryEqual:
+					result = boolToInt(isEqual(left, right))
+				case OpBinaryGreaterThan:
+					result, err = compareNumbers(left, right, ">", func(c int) bool { return c > 0 })
+				case OpBinaryLessThan:
+					result, err = compareNumbers(left, right, "<", func(c int) bool { return c < 0 })
+
+				case OpBinaryConcat:
+					result, err = stringConcat(left, right)
+
+				default:
+					failure = fmt.Errorf("unsupported binary operator %v", binop)
+					break dispatch
+				}
+
+				if err != nil {
+					failure = err
+					break dispatch
+				}
+
+				pushStack(result)
+			case OpNot:
+				v := popStack()
+				i, ok := v.(int)
+				if !ok {
+					failure = fmt.Errorf("operand of NOT operation must be int, but was '%v'", formatValue(v))
+					break dispatch
+				}
+				pushStack(boolToInt(!intToBool(i)))
+			case OpBitwiseNot:
+				result, err := unaryArithmetic(popStack(), "bnot")
+				if err != nil {
+					failure = err
+					break dispatch
+				}
+				pushStack(result)
+			case OpNegate:
+				result, err := unaryArithmetic(popStack(), "-")
+				if err != nil {
+					failure = err
+					break dispatch
+				}
+				pushStack(result)
+			case OpRangeCheck:
+				value := vm.variables[readOpByte()]
+				end := vm.variables[readOpByte()]
+				step := vm.variables[readOpByte()]
+				continues, err := rangeContinues(value, end, step)
+				if err != nil {
+					failure = err
+					break dispatch
+				}
+				pushStack(boolToInt(continues))
+			case OpIterator:
+				it, err := newIterator(popStack(), vm.findMethod)
+				if err != nil {
+					failure = err
+					break dispatch
+				}
+				pushStack(it)
+			case OpIteratorNext:
+				it := vm.variables[readOpByte()].(iterator)
+				keyIndex, valueIndex := readOpByte(), readOpByte()
+				key, value, ok, err := it.next()
+				if err != nil {
+					failure = err
+					break dispatch
+				}
+				if ok {
+					vm.variables[keyIndex] = key
+					vm.variables[valueIndex] = value
+				}
+				pushStack(boolToInt(ok))
+			case OpMatchShape:
+				length := int(readOpByte())
+				hasRest := readOpByte() == 1
+				pushStack(boolToInt(matchesShape(popStack(), length, hasRest)))
+			case OpJumpIfFalse:
+				b1 := int(readOpByte())
+				b2 := int(readOpByte())
+				jumpAmount := b1*256 + b2
+				v := popStack()
+				if !isWeirdlyTrue(v) {
+					ip += jumpAmount
+				}
+			case OpJumpIfTrue:
+				b1 := int(readOpByte())
+				b2 := int(readOpByte())
+				jumpAmount := b1*256 + b2
+				v := popStack()
+				if isWeirdlyTrue(v) {
+					ip += jumpAmount
+				}
+			case OpAttempt:
+				b1 := int(readOpByte())
+				b2 := int(readOpByte())
+				jumpAmount := b1*256 + b2
+				handlers = append(handlers, attemptHandler{failureIp: ip + jumpAmount, stackNext: stackNext})
+			case OpEndAttempt:
+				handlers = handlers[:len(handlers)-1]
+			case OpJumpForward:
+				b1 := int(readOpByte())
+				b2 := int(readOpByte())
+				jumpAmount := b1*256 + b2
+				ip += jumpAmount
+			case OpJumpBack:
+				b1 := int(readOpByte())
+				b2 := int(readOpByte())
+				jumpAmount := b1*256 + b2
+				ip -= jumpAmount
+			case OpInlineNumber:
+				v := int(readOpByte())
+				pushStack(v)
+			case OpLoadConstant:
+				index := int(readOpByte())
+				pushStack(constants[index])
+			case OpLoadNil:
+				pushStack(nil)
+			case OpReadVariable:
+				index := int(readOpByte())
+				value := vm.variables[index]
+				if value == undefined {
+					variableName := vm.variableDefinitions[index]
+					failure = undefinedVariableError(variableName)
+					break dispatch
+				}
+				pushStack(value)
+			case OpSetVariable:
+				index := int(readOpByte())
+				vm.variables[index] = popStack()
+			case OpInstantiate:
+				typeName, err := readConstantString()
+				if err != nil {
+					failure = err
+					break dispatch
+				}
+				vmType, found := types[typeName]
+				if !found {
+					failure = fmt.Errorf("type '%v' not found", typeName)
+					break dispatch
+				}
+				fieldValues := make([]any, len(vmType.Fields))
+				for i := range vmType.Fields {
+					fieldValues[i] = popStack()
+				}
+				slices.Reverse(fieldValues) // Arguments were pushed onto the stack in left-to-right order, so we read them right-to-left
+				instance := VmInstance{
+					vmType: &vmType,
+					values: fieldValues,
+				}
+				pushStack(&instance)
+			case OpCallBuiltin:
+				functionName, err := readConstantString()
+				if err != nil {
+					failure = err
+					break dispatch
+				}
+				builtin, found := builtins[functionName]
+				if !found {
+					failure = fmt.Errorf("builtin function '%v' not found", functionName)
+					break dispatch
+				}
+				arguments := make([]any, builtin.Arity)
+				for i := 0; i < builtin.Arity; i++ {
+					arguments[i] = popStack()
+				}
+				slices.Reverse(arguments) // Arguments were pushed onto the stack in left-to-right order, so we read them right-to-left
+				returnValue, err := builtin.VmFunc(arguments)
+				if err != nil {
+					failure = err
+					break dispatch
+				}
+				pushStack(returnValue)
+			case OpCallFunction:
+				functionName, err := readConstantString()
+				if err != nil {
+					failure = err
+					break dispatch
+				}
+				function := functions[functionName]
+				functionVariables := function.newVariables()
+				for i := len(function.params) - 1; i >= 0; i-- {
+					functionVariables[i] = popStack()
+				}
+
+				outVar, err := vm.callFunction(function, functionVariables, stack[stackNext:])
+				if err != nil {
+					failure = err
+					break dispatch
+				}
+				pushStack(outVar)
+			case OpCallVariadicFunction:
+				functionName, err := readConstantString()
+				if err != nil {
+					failure = err
+					break dispatch
+				}
+				builtin, found := builtins[functionName]
+				if !found {
+					failure = fmt.Errorf("builtin function '%v' not found", functionName)
+					break dispatch
+				}
+				argumentCount := int(readOpByte())
+				arguments := make([]any, argumentCount)
+				for i := 0; i < argumentCount; i++ {
+					arguments[i] = popStack()
+				}
+				slices.Reverse(arguments) // Arguments were pushed onto the stack in left-to-right order, so we read them right-to-left
+				returnValue, err := builtin.VmFunc(arguments)
+				if err != nil {
+					failure = err
+					break dispatch
+				}
+				pushStack(returnValue)
+			case OpFieldAccess:
+				identifier, err := readConstantString()
+				if err != nil {
+					failure = err
+					break dispatch
+				}
+				target := popStack()
+				if toiError, ok := target.(*ToiError); ok {
+					value, err := toiError.field(identifier)
+					if err != nil {
+						failure = err
+						break dispatch
+					}
+					pushStack(value)
+					continue
+				}
+				instance, ok := target.(*VmInstance)
+				if !ok {
+					failure = fmt.Errorf("left-hand operand of '.' must be a type instance but was '%v'", formatValue(target))
+					break dispatch
+				}
+				index, found := instance.vmType.FieldMap[identifier]
+				if !found {
+					methodName := instance.vmType.Name + "." + identifier
+					if _, found := functions[methodName]; found {
+						// instance.method without calling it results in a function value bound to the instance
+						pushStack(&BoundMethod{name: methodName, receiver: instance, method: &VmFunctionValue{name: methodName, vm: vm}})
+						continue
+					}
+					failure = fmt.Errorf("field '%v' not found on type '%v'", identifier, instance.vmType.Name)
+					break dispatch
+				}
+				pushStack(instance.values[index])
+			case OpSetField:
+				identifier, err := readConstantString()
+				if err != nil {
+					failure = err
+					break dispatch
+				}
+				value := popStack()
+				target := popStack()
+				instance, ok := target.(*VmInstance)
+				if !ok {
+					failure = fmt.Errorf("left-hand operand of '.' must be a type instance but was '%v'", formatValue(target))
+					break dispatch
+				}
+				index, found := instance.vmType.FieldMap[identifier]
+				if !found {
+					failure = fmt.Errorf("field '%v' not found on type '%v'", identifier, instance.vmType.Name)
+					break dispatch
+				}
+				instance.values[index] = value
+			case OpDuplicate:
+				v := popStack()
+				pushStack(v)
+				pushStack(v)
+			case OpDuplicatePair:
+				pushStack(stack[stackNext-2])
+				pushStack(stack[stackNext-2])
+			case OpFunctionReference:
+				functionName, err := readConstantString()
+				if err != nil {
+					failure = err
+					break dispatch
+				}
+				pushStack(&VmFunctionValue{name: functionName, vm: vm})
+			case OpBuiltinReference:
+				functionName, err := readConstantString()
+				if err != nil {
+					failure = err
+					break dispatch
+				}
+				pushStack(&BuiltinFunctionValue{name: functionName, builtin: builtins[functionName]})
+			case OpCallValue:
+				argumentCount := int(readOpByte())
+				arguments := make([]any, argumentCount)
+				for i := argumentCount - 1; i >= 0; i-- {
+					arguments[i] = popStack()
+				}
+				value := popStack()
+
+				var returnValue any
+				var err error
+				if function, ok := value.(*VmFunctionValue); ok {
+					returnValue, err = function.callWithStack(arguments, stack[stackNext:])
+				} else if function, ok := value.(callable); ok {
+					returnValue, err = function.call(arguments)
+				} else {
+					failure = notAFunctionError(value)
+					break dispatch
+				}
+				if err != nil {
+					failure = err
+					break dispatch
+				}
+				pushStack(returnValue)
+
+			case OpCallMethod:
+				methodName, err := readConstantString()
+				if err != nil {
+					failure = err
+					break dispatch
+				}
+				argumentCount := int(readOpByte())
+				arguments := make([]any, argumentCount)
+				for i := argumentCount - 1; i >= 0; i-- {
+					arguments[i] = popStack()
+				}
+				receiver := popStack()
+				instance, ok := receiver.(*VmInstance)
+				if !ok {
+					failure = fmt.Errorf("left-hand operand of '.' must be a type instance but was '%v'", formatValue(receiver))
+					break dispatch
+				}
+
+				var returnValue any
+				if function, found := functions[instance.vmType.Name+"."+methodName]; found {
+					if argumentCount != len(function.params)-1 {
+						failure = fmt.Errorf("expected %d arguments but got %d for method '%s'", len(function.params)-1, argumentCount, instance.vmType.Name+"."+methodName)
+						break dispatch
+					}
+					functionVariables := function.newVariables()
+					functionVariables[0] = instance
+					copy(functionVariables[1:], arguments)
+					returnValue, err = vm.callFunction(function, functionVariables, stack[stackNext:])
+				} else if function, ok := fieldFunction(instance, methodName); ok {
+					// A field holding a function value can be called like a method (but without a receiver)
+					returnValue, err = function.call(arguments)
+				} else {
+					failure = fmt.Errorf("method '%v' not found on type '%v'", methodName, instance.vmType.Name)
+					break dispatch
+				}
+				if err != nil {
+					failure = err
+					break dispatch
+				}
+				pushStack(returnValue)
+
+			case OpDestructure:
+				count := int(readOpByte())
+				values, err := destructure(popStack(), count)
+				if err != nil {
+					failure = err
+					break dispatch
+				}
+				for i := count - 1; i >= 0; i-- {
+					pushStack(values[i])
+				}
 
 			default:
-				return fmt.Errorf("unsupported binary operator %v at %d", binop, ip)
+				failure = fmt.Errorf("unknown instruction %v", instruction)
+				break dispatch
 			}
+		}
 
-			if err != nil {
-				return err
-			}
+		if failure == nil {
+			return nil
+		}
+		// The position is only looked up when an error happens; ip is within the op that failed, after its operands
+		toiError := toToiError(failure, vm.position(ip-1))
+		if len(handlers) == 0 {
+			return toiError
+		}
+		handler := handlers[len(handlers)-1]
+		handlers = handlers[:len(handlers)-1]
+		ip, stackNext, failure = handler.failureIp, handler.stackNext, nil
+		pushStack(toiError)
+	}
+}
 
-			pushStack(result)
-		case OpNot:
-			v := popStack()
-			i, ok := v.(int)
-			if !ok {
-				return fmt.Errorf("operand of NOT operation must be int, but was '%v' at %d", v, ip)
-			}
-			pushStack(boolToInt(!intToBool(i)))
-		case OpJumpIfFalse:
-			b1 := int(readOpByte())
-			b2 := int(readOpByte())
-			jumpAmount := b1*256 + b2
-			v := popStack()
-			if !isWeirdlyTrue(v) {
-				ip += jumpAmount
-			}
-		case OpJumpForward:
-			b1 := int(readOpByte())
-			b2 := int(readOpByte())
-			jumpAmount := b1*256 + b2
-			ip += jumpAmount
-		case OpJumpBack:
-			b1 := int(readOpByte())
-			b2 := int(readOpByte())
-			jumpAmount := b1*256 + b2
-			ip -= jumpAmount
-		case OpInlineNumber:
-			v := int(readOpByte())
-			pushStack(v)
-		case OpLoadConstant:
-			index := int(readOpByte())
-			pushStack(constants[index])
-		case OpReadVariable:
-			index := int(readOpByte())
-			value := vm.variables[index]
-			if value == nil {
-				variableName := vm.variableDefinitions[index]
-				return fmt.Errorf("variable '%v' not defined at %d", variableName, ip)
-			}
-			pushStack(value)
-		case OpSetVariable:
-			index := int(readOpByte())
-			vm.variables[index] = popStack()
-		case OpInstantiate:
-			typeName, err := readConstantString()
-			if err != nil {
-				return err
-			}
-			vmType, found := types[typeName]
-			if !found {
-				return fmt.Errorf("type '%v' not found at %d", typeName, ip)
-			}
-			fieldValues := make([]any, len(vmType.Fields))
-			for i := range vmType.Fields {
-				fieldValues[i] = popStack()
-			}
-			slices.Reverse(fieldValues) // Arguments were pushed onto the stack in left-to-right order, so we read them right-to-left
-			instance := VmInstance{
-				vmType: &vmType,
-				values: fieldValues,
-			}
-			pushStack(&instance)
-		case OpCallBuiltin:
-			functionName, err := readConstantString()
-			if err != nil {
-				return err
-			}
-			builtin, found := builtins[functionName]
-			if !found {
-				return fmt.Errorf("builtin function '%v' not found at %d", functionName, ip)
-			}
-			arguments := make([]any, builtin.Arity)
-			for i := 0; i < builtin.Arity; i++ {
-				arguments[i] = popStack()
-			}
-			slices.Reverse(arguments) // Arguments were pushed onto the stack in left-to-right order, so we read them right-to-left
-			returnValue, err := builtin.VmFunc(arguments)
-			if err != nil {
-				return err
-			}
-			pushStack(returnValue)
-		case OpCallFunction:
-			functionName, err := readConstantString()
-			if err != nil {
-				return err
-			}
-			function := functions[functionName]
-			functionVariables := make([]any, len(function.variableDefinitions))
-			for i := len(function.params) - 1; i >= 0; i-- {
-				functionVariables[i] = popStack()
-			}
+// attemptHandler is where the VM continues when an error happens in an attempt block
+type attemptHandler struct {
+	failureIp int
+	stackNext int
+}
 
-			functionVm := &Vm{
-				ops:                 function.ops,
-				constants:           constants,
-				functions:           functions,
-				variables:           functionVariables,
-				variableDefinitions: function.variableDefinitions,
-				types:               types,
-			}
+// position returns the position of the innermost statement that the op at the index was compiled from
+func (vm *Vm) position(index int) LineCol {
+	var lineCol LineCol
+	size := -1
+	for _, position := range vm.positions {
+		// Statements are added after the statements they contain, so the first match of the smallest size is the
+		// innermost one
+		if position.start <= index && index < position.end && (size < 0 || position.end-position.start < size) {
+			lineCol, size = position.lineCol, position.end-position.start
+		}
+	}
+	return lineCol
+}
 
-			err = functionVm.execute(stack[stackNext:])
-			if err != nil {
-				return err
-			}
+// callFunction executes the function with the given variables, of which the parameters should already be set
+func (vm *Vm) callFunction(function VmFunction, functionVariables []any, stack []any) (any, error) {
+	functionVm := &Vm{
+		ops:                 function.ops,
+		constants:           vm.constants,
+		functions:           vm.functions,
+		variables:           functionVariables,
+		variableDefinitions: function.variableDefinitions,
+		types:               vm.types,
+		positions:           function.positions,
+	}
 
-			var outVar any = nil
-			if function.hasOutVar {
-				// E.g. if a function has 2 input parameters, and 1 output parameter, then the variable spot for the
-				// output parameter is right after the input parameters, i.e. in the 3rd spot, or index 2, which is the
-				// length of the params slice
-				outVar = functionVariables[len(function.params)]
-			}
+	err := functionVm.execute(stack)
+	if err != nil {
+		return nil, err
+	}
 
-			pushStack(outVar)
-		case OpCallVariadicFunction:
-			functionName, err := readConstantString()
-			if err != nil {
-				return err
-			}
-			builtin, found := builtins[functionName]
-			if !found {
-				return fmt.Errorf("builtin function '%v' not found at %d", functionName, ip)
-			}
-			argumentCount := int(readOpByte())
-			arguments := make([]any, argumentCount)
-			for i := 0; i < argumentCount; i++ {
-				arguments[i] = popStack()
-			}
-			slices.Reverse(arguments) // Arguments were pushed onto the stack in left-to-right order, so we read them right-to-left
-			returnValue, err := builtin.VmFunc(arguments)
-			if err != nil {
-				return err
-			}
-			pushStack(returnValue)
-		case OpFieldAccess:
-			identifier, err := readConstantString()
-			if err != nil {
-				return err
-			}
-			target := popStack()
-			instance, ok := target.(*VmInstance)
-			if !ok {
-				return fmt.Errorf("left-hand operand of '.' must be a type instance but was '%v'", target)
-			}
-			index, found := instance.vmType.FieldMap[identifier]
-			if !found {
-				return fmt.Errorf("field '%v' not found on type '%v'", identifier, instance.vmType.Name)
-			}
-			pushStack(instance.values[index])
-		case OpSetField:
-			identifier, err := readConstantString()
-			if err != nil {
-				return err
-			}
-			value := popStack()
-			target := popStack()
-			instance, ok := target.(*VmInstance)
-			if !ok {
-				return fmt.Errorf("left-hand operand of '.' must be a type instance but was '%v'", target)
-			}
-			index, found := instance.vmType.FieldMap[identifier]
-			if !found {
-				return fmt.Errorf("field '%v' not found on type '%v'", identifier, instance.vmType.Name)
-			}
-			instance.values[index] = value
-		case OpDuplicate:
-			v := popStack()
-			pushStack(v)
-			pushStack(v)
-
-		default:
-			return fmt.Errorf("unknown instruction %v at %d", instruction, ip)
-		}
+	// E.g. if a function has 2 input parameters, and 1 output parameter, then the variable spot for the output
+	// parameter is right after the input parameters, i.e. in the 3rd spot, or index 2, which is the length of the
+	// params slice
+	var outVar any = nil
+	if function.outVarCount == 1 {
+		outVar = functionVariables[len(function.params)]
+	} else if function.outVarCount > 1 {
+		// Multiple out-variables are returned as a tuple
+		outVar = &Tuple{values: slices.Clone(functionVariables[len(function.params) : len(function.params)+function.outVarCount])}
 	}
+	return outVar, nil
+}
 
-	return nil
+// fieldFunction returns the value of a field of the instance if it holds a function, so that it can be called like a
+// method
+func fieldFunction(instance *VmInstance, fieldName string) (callable, bool) {
+	index, found := instance.vmType.FieldMap[fieldName]
+	if !found {
+		return nil, false
+	}
+	function, ok := instance.values[index].(callable)
+	return function, ok
 }